@@ -23,14 +23,73 @@
 package easyrest
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"golang.org/x/sync/singleflight"
+	"hash/fnv"
 	"log"
+	"log/slog"
+	"maps"
+	"math"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"runtime/debug"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
+// SubEntity is a read only list of children of an item, exposed as /:id/SubPath
 type SubEntity[T any, D any] struct {
+	SubPath  string
+	Get      func(item T) []any
+	FindOne  func(parent T, subID string) (any, bool) // Find a single child, exposed as /:id/SubPath/:subId if set
+	Dto      func(child any) any                      // Transform each child for the JSON, if nil the children are sent as is
+	Disabled bool                                     // Don't register the routes of this SubEntity
+
+	// Delete a child, exposed as DELETE /:id/SubPath/:subId if set and checked with the Validator as ActionDelete
+	// of the parent.  It should return ErrNotFound for a missing child.
+	Delete func(parent T, subID string) error
+	// Embeds are the children of the children that ?embed= can nest under them, e.g. ?embed=SubPath.lines
+	Embeds []Embed
+}
+
+// dto transforms the child with the Dto if set
+func (subEntity SubEntity[T, D]) dto(child any) any {
+	if subEntity.Dto == nil {
+		return child
+	}
+	return subEntity.Dto(child)
+}
+
+// GroupByResource is a read only aggregate of the collection exposed as /group/SubPath.
+// The items of FindAll are bucketed by KeyFn and each bucket is reduced, e.g. to a count, by Reduce.
+type GroupByResource[T any] struct {
+	SubPath string
+	KeyFn   func(item T) string
+	Reduce  func(items []T) any
+}
+
+// CustomAction is a domain specific operation on a single item exposed as Method /:id/SubPath.
+// The item is found and checked with the Validator for Action before Handler is called,
+// the item Handler returns is sent as its Dto.
+type CustomAction[T any, D any] struct {
+	Method  string
 	SubPath string
-	Get     func(item T) []any
+	Action  Action
+	Handler func(c *fiber.Ctx, item T) (T, error)
 }
 
 // Api is the easy rest/crud API for Fiber.
@@ -44,7 +103,7 @@ type SubEntity[T any, D any] struct {
 type Api[T any, D any] struct {
 	Path        string                     // The path of the api under the parent
 	Find        func(key string) (T, bool) // Find one method
-	FindAllPage func(ID int64) Page[T]     //paginator.Page[T]   // Find all method
+	FindAllPage func(ID int64) Page[T]     // Find a page method, if nil pages are sliced from FindAll
 	FindAll     func() []T
 	Search      func(D) []T                                       // Search using D as a filter
 	Mutate      func(T, D) (T, error)                             // Mutation function for "PUT".  If nil, no mutation is exposed
@@ -53,271 +112,3021 @@ type Api[T any, D any] struct {
 	SubEntities []SubEntity[T, D]                                 // SubEntities to expose as read only lists
 	Dto         func(T) D                                         // Fill a DTO for T
 	Validator   func(c *fiber.Ctx, action Action, item ...T) bool // Access check, T will be missing for aggregate functions or if the item is not found
+
+	// AuthPolicy decides access in place of the Validator when set, e.g. backed by a policy engine.
+	// A denial is answered like a Validator rejection, an error with 500 (Internal Server Error).
+	AuthPolicy AuthPolicy
+
+	// Context aware variants of the data functions.  When set they are used in place of their plain counterparts
+	// and receive the request context, bounded by Timeout if one is configured.
+	FindCtx    func(ctx context.Context, key string) (T, bool)
+	FindAllCtx func(ctx context.Context) []T
+	SearchCtx  func(ctx context.Context, filter D) []T
+	MutateCtx  func(ctx context.Context, item T, edit D) (T, error)
+	CreateCtx  func(ctx context.Context, edit D) (T, error)
+	DeleteCtx  func(ctx context.Context, item T) (T, error)
+
+	// FindKey is a Find that can reject malformed keys with an error, answered with 400 (Bad Request).
+	// It is used in place of Find when set, use TypedFind to build one around a strongly typed key.
+	FindKey func(key string) (T, bool, error)
+
+	// Timeout bounds the data work of each request, exceeding it returns 504 (Gateway Timeout).
+	// Only the context aware variants can be cancelled, so the timeout only applies to them.
+	Timeout time.Duration
+
+	ExposeSchema bool // Expose a JSON Schema of the DTO type D as GET /schema
+
+	// Exists reports if a resource equivalent to D is already present.
+	// When set a create with "If-None-Match: *" fails with 412 (Precondition Failed) if it already exists.
+	Exists func(D) bool
+
+	// IdempotencyTTL keeps the response of each successful POST / made with an Idempotency-Key header for the
+	// duration, and answers a retry with the same key with it, flagged Idempotent-Replayed, rather than creating again.
+	// A retry with a different body is 422 (Unprocessable Entity).  The replay follows the Validator and precedes the
+	// Exists check, so a retried "If-None-Match: *" create gets its original response, not a 412 for the item it
+	// created itself.  If zero the Idempotency-Key header is ignored.
+	IdempotencyTTL time.Duration
+
+	EnableProblemJSON bool // Send error responses as application/problem+json (RFC 7807) bodies
+
+	// FindAllPageSized finds a page of the size requested with ?size=, it takes precedence over FindAllPage.
+	// The size is clamped to MaxPageSizeFor the caller if set, otherwise to MaxPageSize.
+	FindAllPageSized func(page int64, size int) Page[T]
+	DefaultPageSize  int                    // Page size when none is requested with ?size=, defaults to 10
+	MaxPageSize      int                    // Cap on the page size requested with ?size=, defaults to 10000
+	MaxPageSizeFor   func(c *fiber.Ctx) int // Cap on the page size of the caller, e.g. by plan, 0 or less for MaxPageSize
+	MaxPage          int64                  // Highest page number served, 0 for no cap.  Beyond it is 400 unless ClampPage is set, as is below 1
+	ClampPage        bool                   // Serve the MaxPage for page numbers beyond it instead of 400
+
+	// DisableLeakProtection returns 404 on a missed lookup without first consulting the Validator.
+	// This saves a Validator call for public resources, but reveals to unauthorized callers which items exist,
+	// so only disable it when the existence of items is not sensitive.
+	// It is the same as PrecedenceAlwaysNotFoundFirst, which takes over when NotFoundPrecedence is left at its default.
+	DisableLeakProtection bool
+
+	// NotFoundPrecedence orders the existence and authorization checks of the item level actions,
+	// see the NotFoundPrecedence constants for what each discloses.  Defaults to PrecedenceLeak.
+	NotFoundPrecedence NotFoundPrecedence
+
+	// PutCreatesWithPathID makes a PUT to a missing item create it with CreateWithID using the id from the path.
+	// A create responds 201 (Created) with a Location header, an update of an existing item 200.
+	// When false, or CreateWithID is nil, a PUT to a missing item is 404.
+	// Two concurrent PUTs can both miss the item and take the create path, so for the status to be reliable
+	// CreateWithID must refuse an id that already exists by returning ErrConflict, answered with 409 (Conflict).
+	// CreateWithID also serves POST / bodies carrying an id field, creating the item with the client supplied id,
+	// 409 (Conflict) if the id already exists.  Without CreateWithID the id of a POST is left to Create.
+	PutCreatesWithPathID bool
+	CreateWithID         func(id string, d D) (T, error) // Create function using a client supplied id
+
+	// GenerateID generates the id of a POST / body without one, e.g. a UUID or ULID, which CreateWithGeneratedID
+	// creates the item with.  The create responds 201 (Created) with the Location of the item.
+	// When either is nil, Create creates the item.
+	GenerateID            func() string
+	CreateWithGeneratedID func(id string, d D) (T, error)
+
+	// ResponseInterceptor is called with every response body before it is serialized, single items and collections alike.
+	// The returned value is sent in its place, allowing bodies to be wrapped or decorated uniformly.
+	ResponseInterceptor func(c *fiber.Ctx, action Action, body any) any
+
+	AllowExport bool // Expose GET /export streaming the whole collection as one JSON array
+
+	Marshal     func(any) ([]byte, error) // JSON serializer for this Api, if nil Fiber's configured encoder is used
+	ContentType string                    // Content type of the JSON responses, e.g. application/vnd.myapp+json, if empty application/json
+
+	// ErrorMapper chooses the HTTP status for an error returned by a data function.
+	// Returning 0 falls back to the default mapping, 503 for ErrOverloaded and 500 otherwise.
+	ErrorMapper func(err error) int
+
+	CustomActions []CustomAction[T, D] // Additional item level operations
+
+	// EnableCollectionETag sets an ETag on getAll and answers a matching If-None-Match with 304 (Not Modified).
+	// The ETag is CollectionVersion if provided, otherwise a weak ETag hashing the serialized collection.
+	// Hashing has to find and serialize the collection on every request so prefer CollectionVersion when possible.
+	EnableCollectionETag bool
+	CollectionVersion    func() string
+
+	// Subscribe supplies the changes to stream as Server-Sent Events on GET /events.
+	// ctx is cancelled when the client disconnects, the channel should then be closed.
+	Subscribe func(ctx context.Context) <-chan ChangeEvent
+
+	// DeleteN is a Delete reporting how many records were affected, e.g. by cascading to children.
+	// It takes precedence over Delete and DeleteCtx and makes DELETE respond {"deleted": n}.
+	DeleteN func(T) (affected int, err error)
+
+	Tracer Tracer // Traces each request in a span, if nil there is no tracing
+
+	// SearchQuery searches with D as a filter, returning the page and sort order given in opts.
+	// When set it serves POST /filter in place of Search, responding with a Page of D.
+	SearchQuery func(filter D, opts QueryOptions) Page[T]
+
+	// SearchBool searches with a BoolQuery combining several filters, in place of Search on POST /filter
+	SearchBool func(query BoolQuery[D]) []T
+
+	// IsGone reports if an id Find missed did exist but has been permanently deleted, answered with 410 (Gone).
+	// If nil a miss is always 404.
+	IsGone func(id string) bool
+
+	// DtoWithOpts is a Dto for single items receiving the raw query values of the request, e.g. ?expand=owner.
+	// Interpreting them is up to the app.  If nil, Dto is used.  Only one of the Dto variants may be set, see dtoHooks.
+	DtoWithOpts func(t T, opts url.Values) D
+
+	// MaxSearchResults caps the results of Search, 0 for no cap.
+	// Beyond it the search is 422 (Unprocessable Entity) unless TruncateSearch is set, in which case the
+	// results are truncated and flagged with the X-Results-Truncated header.
+	MaxSearchResults int
+	TruncateSearch   bool
+
+	// SearchFacets counts the items matching a Search filter by the values of facet fields, e.g.
+	// {"status": {"active": 42, "archived": 7}}.  When set the searches of /filter with a filter respond
+	// {"data": results, "facets": counts} rather than the bare results.
+	SearchFacets func(filter D) map[string]map[string]int
+
+	// AllowedFilterFields lists the fields the searches of /filter may filter on, e.g. to keep sensitive columns
+	// from being probed.  A filter setting any other field is 400 with the disallowed fields in the body, as a
+	// ValidationError, before Search runs.  If empty all fields may be filtered on.
+	AllowedFilterFields []string
+
+	// Replica variants of Find and FindAll, preferred for reads when set.
+	// A request with the ConsistencyHeader set to "strong" reads from the primary to see its own writes.
+	FindReplica       func(key string) (T, bool)
+	FindAllReplica    func() []T
+	ConsistencyHeader string // Defaults to "Consistency"
+
+	// StaleWarnings flags the reads that may not be fresh with a Warning header, 110 for pages served from the
+	// CacheTTL cache and 111 for reads from a replica, so clients know to refetch if they need fresh data.
+	StaleWarnings bool
+
+	// OwnershipCheck enforces that the caller owns the item on the item level actions, once Find has found it and
+	// the Validator has passed.  Returning false is 403 (Forbidden).  If nil there is no ownership check.
+	OwnershipCheck func(c *fiber.Ctx, item T) bool
+
+	// Timestamps of an item, sent as the Last-Modified and X-Created-At headers of single item responses if set
+	LastModified func(T) time.Time
+	CreatedAt    func(T) time.Time
+
+	// RequireJSONContentType rejects request bodies not declared as application/json with
+	// 415 (Unsupported Media Type) before parsing them.
+	RequireJSONContentType bool
+
+	MGetAsMap bool // Respond to POST /mget with a map of id to Dto rather than an array in request order
+
+	CORS *CORSConfig // CORS handling for just this resource, if nil no CORS headers are added
+
+	// ApplyPatch persists an item patched by PATCH /:id with an application/json-patch+json (RFC 6902) body.
+	// The patch is applied to the json of the stored T, but may only touch the fields its Dto exposes (422 otherwise)
+	// and must leave the ImmutableFields unchanged (400).  If nil, PATCH is not exposed.
+	ApplyPatch func(T) (T, error)
+
+	// DeleteAll deletes the whole collection, returning how many items were deleted.
+	// When set it is exposed as DELETE /?confirm=true, checked with the Validator as ActionDeleteAll.
+	DeleteAll func(c *fiber.Ctx) (int, error)
+
+	// FindByFilter finds the single item matching D, e.g. by a composite key that does not fit a path.
+	// When set it is exposed as POST /find.
+	FindByFilter func(D) (T, bool)
+
+	// Version of an item, sent as its strong ETag on getOne.  A matching If-None-Match is 304 (Not Modified).
+	Version func(T) string
+
+	// Indexes are secondary keys of the items, by field name, e.g. "email".  Each is exposed as GET /by/field/:value
+	// returning the Dtos of the items with the key, or 404 if there are none.  The indexes are built from FindAll
+	// on first use and kept until a write to the resource.
+	Indexes map[string]func(T) string
+
+	// AllowEmptyBody lets a PUT or PATCH of an item carry an empty body, mutating it with the zero D.
+	// By default an empty or whitespace only body is 400 so a bare request cannot wipe the item.
+	AllowEmptyBody bool
+
+	// RequireIfMatch makes PUT, PATCH and DELETE of an item 428 (Precondition Required) without an If-Match header,
+	// before any work is done, so that clients take part in optimistic concurrency.  When Version is set an If-Match
+	// that does not match the Version of the item is 412 (Precondition Failed).
+	RequireIfMatch bool
+
+	// Ancestors lists the ancestors of a hierarchical item, e.g. the parent categories of a category, in order from
+	// the root down to its parent.  Exposed as GET /:id/ancestors when set, sending the ancestors that are a T as
+	// their Dto and any others as is.
+	Ancestors func(t T) []any
+
+	// IsZero reports if a found item is the zero T, which a buggy Find may return with ok.  When set getOne, and
+	// the item level actions finding the item as it does, treat such an item as not found, 404, rather than
+	// serializing an empty object.
+	IsZero func(T) bool
+
+	// Enrich augments an item after it is found for a read and before its Dto, e.g. with related counts
+	// that are not stored on T.  Collections enrich each item.  If nil, items are used as found.
+	Enrich func(c *fiber.Ctx, t T) T
+
+	// IdempotentDelete makes deleting an absent item 204 (No Content) rather than 404, if the Validator permits it
+	IdempotentDelete bool
+
+	Logger *slog.Logger // Logger for diagnostics, if nil the standard log package is used
+
+	// RecoverPanics recovers panics in the handlers, e.g. from a buggy Dto, logging them and responding 500 without
+	// the headers the handler had set, such as an ETag or Location, other than the CORS and APIVersion ones.
+	// A panic while streaming an export or NDJSON is logged and cuts the stream short.
+	// Leave it off if Fiber's recover middleware already handles them.
+	RecoverPanics bool
+
+	GroupBy []GroupByResource[T] // Aggregates of the collection to expose as GET /group/SubPath
+
+	// CreateMultipart creates an item from a multipart/form-data POST /, e.g. a file upload with its metadata.
+	// The non-file fields are bound into D and the files are passed by form field name.
+	// It takes precedence over Create, which still serves POST / bodies that are not multipart.
+	CreateMultipart func(c *fiber.Ctx, files map[string][]*multipart.FileHeader, fields D) (T, error)
+
+	// MaxBodyBytes caps the size of request bodies, larger ones are 413 (Request Entity Too Large).  0 for no cap.
+	// The declared Content-Length is checked before the body, but Fiber reads the whole body before any handler
+	// runs, so it is the BodyLimit of the app that bounds the memory a request takes, MaxBodyBytes cannot raise it.
+	MaxBodyBytes int
+
+	// SensitiveFields are the json names of fields whose values are logged as "***", e.g. passwords or tokens
+	SensitiveFields []string
+
+	// Draining reports if the service is draining before shutdown.  While it does, the writes, creates, mutations
+	// and deletes of single items and batches alike, are 503 (Service Unavailable) with a Retry-After, and the
+	// reads are served as usual.  If nil the Api never drains.
+	Draining func() bool
+
+	// CaptureFailed is handed a RequestSnapshot of every request a handler fails with a 5xx status, e.g. to
+	// persist failing requests for replay.  The credential headers, e.g. Authorization and Cookie, and the headers
+	// and body fields named in SensitiveFields are redacted.
+	CaptureFailed func(req RequestSnapshot)
+
+	// SortableFields lists the fields a SearchQuery may sort by, a sort by any other field is 400.
+	// If empty any sort is passed to SearchQuery.
+	SortableFields []string
+
+	// StreamIdleTimeout aborts a streamed response, such as GET /export or NDJSON, when the client consumes nothing for this long,
+	// so abandoned clients do not hold on to the stream.  0 for no idle timeout.
+	StreamIdleTimeout time.Duration
+
+	// Links of an item to related resources, sent as the _links of single item responses, by relation name.
+	// If nil the Dto is sent unchanged.
+	Links func(t T) map[string]string
+
+	// EnqueueBatch enqueues the work of the batch endpoints, POST /batch and DELETE /batch, returning the id of the job.
+	// They then respond 202 (Accepted) with {"jobId": id}, rather than processing the batch within the request.
+	// JobStatus reports the status of a job, exposed as GET /jobs/:jobId, false if the job is unknown.
+	EnqueueBatch func(action Action, items []D) (jobID string, err error)
+	JobStatus    func(jobID string) (status any, ok bool)
+
+	// CacheTTL caches the responses of GET /page/:id for the duration, by page number and query, serving repeated
+	// requests for a page the cached bytes.  Concurrent requests for an uncached page share a single lookup.
+	// The Validator still checks each request, and any successful write drops every cached page as paging shifts.
+	// Pages are not cached when Enrich or ResponseInterceptor is set, as they may differ for each request.
+	CacheTTL time.Duration
+
+	// MaxBatchSize bounds the items of the bulk endpoints, POST /batch, DELETE /batch and POST /mget.
+	// Larger batches are 413 (Payload Too Large) with the limit and the count, before any item is processed.
+	// If zero there is no limit.
+	MaxBatchSize int
+
+	// PageRenderer reshapes the pages of GET /page/:id and SearchQuery before they are sent, e.g. to rename the fields.
+	// If nil the Page is sent as is.
+	PageRenderer func(page Page[D]) any
+
+	// Singleflight coalesces concurrent getOne requests for the same id into a single Find, sharing its result.
+	// The Validator still checks each request.
+	Singleflight bool
+
+	// DtoE is a Dto that can fail, e.g. resolving a reference, used in place of Dto when set.
+	// Its error is answered as the errors of the data functions, by default 500.  In a collection one failing item
+	// fails the whole response.  Only one of the Dto variants may be set, see dtoHooks.
+	DtoE func(t T) (D, error)
+
+	// Middleware to run before the handlers of the routes of an action only, e.g. a stricter limiter for ActionCreate.
+	// It applies to every route of the action.
+	Middleware map[Action][]fiber.Handler
+
+	// FindDeleted finds the soft deleted items, exposed as GET /trash and checked with the Validator as ActionViewTrash
+	FindDeleted func() []T
+
+	// ReturnDiff adds the fields of the Dto a PUT changed to its response, as _changes mapping each field to its
+	// FieldChange.  Unchanged fields are omitted.
+	ReturnDiff bool
+
+	// MaxEmbedDepth bounds the nesting, dot separated, of the SubEntities and their Embeds a getOne or createOne may
+	// embed with ?embed=, deeper embeds are 400.  Defaults to 1.  The embedded children are sent as the _embedded
+	// field by SubPath, the children embedded under them in an _embedded field of their own.
+	MaxEmbedDepth int
+
+	// GroupFallback answers any path under the Api that no route matches with a json 404.
+	// Leave it off if the app has its own catch all.
+	GroupFallback bool
+
+	// StatusMapper remaps the status of every response as the handler returns, e.g. for a gateway with its
+	// own conventions.  Only the status changes, not the body.  An error a handler returns is mapped by the status
+	// the error handler would answer it with.  If nil statuses are sent as is.
+	StatusMapper func(action Action, status int) int
+
+	// DtoWithIncludes is a Dto told which optional fields the request asked for with ?include=, e.g. ?include=score,
+	// so expensive fields can be skipped when not wanted.  It serves single items and collections.  If nil, Dto is used.
+	// Only one of the Dto variants may be set, see dtoHooks.
+	DtoWithIncludes func(t T, includes map[string]bool) D
+
+	// DtoLocalized is a Dto for localized content, told the locale negotiated with the Accept-Language header of a
+	// read, or DefaultLocale if the header names none.  It serves single items and collections.  If nil, Dto is used.
+	// Only one of the Dto variants may be set, see dtoHooks.
+	DtoLocalized  func(t T, locale string) D
+	DefaultLocale string
+
+	// PreprocessBody normalizes the raw body of a request before it is parsed, e.g. coercing numbers sent as strings.
+	// An error is 400 (Bad Request).  If nil bodies are parsed as sent.
+	PreprocessBody func(raw []byte) ([]byte, error)
+
+	EmitGRPCStatus bool // Set the grpc-status header to the gRPC equivalent of the HTTP status, for gRPC-Web bridges
+
+	// DtoBatch transforms the items of a collection in one call, e.g. to look up their references together, in place of
+	// calling Dto per item.  It must return a Dto for each item, in order.  If nil, Dto is used.
+	// Single items still use Dto.  Only one of the Dto variants may be set, see dtoHooks.
+	DtoBatch func(items []T) []D
+
+	RateLimits map[Action]RateLimit // Limits on the request rate of each client, by action.  Unlisted actions are not limited
+
+	EnumValues map[string][]string // The possible values of enum like fields, by field, exposed as GET /_enums
+
+	// ImmutableFields are the json names of the fields of D a PUT may not change, e.g. id or createdAt.
+	// A PUT submitting a different value is 400 listing them in {"errors": ...} before Mutate is called.
+	ImmutableFields []string
+
+	// SlowThreshold logs a warning for each call of a data function, such as Find or Mutate, taking longer than it.
+	// 0 for no slow logging.
+	SlowThreshold time.Duration
+
+	// CanonicalID resolves an alias id, such as a slug, to the canonical id of the item.
+	// A getOne of an alias is redirected with 301 (Moved Permanently) to the canonical url, once the Validator allows
+	// the caller ActionGetOne, as for an item that is not found.
+	CanonicalID func(requestedID string) (canonicalID string, isAlias bool)
+
+	// StreamNDJSON streams getAll and the SubEntity lists as newline delimited json to requests accepting
+	// application/x-ndjson, rather than buffering one json array.  Streamed items are not Enriched.
+	StreamNDJSON bool
+
+	// APIVersion mounts the Api under /APIVersion/Path, e.g. /v2/items, and is sent in the X-API-Version header
+	APIVersion string
+
+	mount *mount // The state of the registration, set by RegisterAPI
+}
+
+// mount is the state shared by the handlers of one registration of an Api
+type mount struct {
+	flight     singleflight.Group
+	limiters   map[Action]*limiter
+	pages      pageCache
+	indexes    indexCache
+	idempotent idempotencyStore
+}
+
+// limiter is the limiter of the RateLimits of action, nil if it is not limited
+func (m *mount) limiter(action Action) *limiter {
+	if m == nil {
+		return nil
+	}
+	return m.limiters[action]
+}
+
+// found is the shared result of a coalesced Find
+type found[T any] struct {
+	item T
+	ok   bool
+}
+
+type Action uint8
+
+const (
+	ActionGetAll Action = iota
+	ActionGetOne
+	ActionMutate
+	ActionCreate
+	ActionDelete
+	ActionDeleteAll
+	ActionViewTrash
+)
+
+var actionNames = map[Action]string{
+	ActionGetAll:    "getAll",
+	ActionGetOne:    "getOne",
+	ActionMutate:    "mutate",
+	ActionCreate:    "create",
+	ActionDelete:    "delete",
+	ActionDeleteAll: "deleteAll",
+	ActionViewTrash: "viewTrash",
+}
+
+// AuthPolicy is a declarative access check, consulted for each action.
+// The resource is the item acted upon, or nil for aggregate actions and missed lookups.
+type AuthPolicy interface {
+	Allow(ctx *fiber.Ctx, action Action, resource any) (bool, error)
+}
+
+// NotFoundPrecedence is the order in which an item level action checks that the item exists and that the caller
+// may access it, trading the information disclosed to unauthorized callers against Validator calls.
+type NotFoundPrecedence uint8
+
+const (
+	// PrecedenceLeak looks the item up first, but consults the Validator before answering a miss,
+	// so unauthorized callers get 401 whether or not the item exists.  The response does not leak existence,
+	// but the timing of the lookup may.
+	PrecedenceLeak NotFoundPrecedence = iota
+	// PrecedenceAlwaysForbidFirst answers any denial with 403 (Forbidden), for absent and present items alike,
+	// so unauthenticated callers probing ids only ever see 403.  As with PrecedenceLeak the timing may leak existence.
+	PrecedenceAlwaysForbidFirst
+	// PrecedenceAlwaysNotFoundFirst answers a miss with 404 without consulting the Validator.
+	// It discloses to unauthorized callers which items exist: 404 for absent items and 401 for present ones.
+	PrecedenceAlwaysNotFoundFirst
+)
+
+func (a Action) String() string {
+	if name, ok := actionNames[a]; ok {
+		return name
+	}
+	return "action(" + strconv.Itoa(int(a)) + ")"
+}
+
+// ChangeEvent describes a change to an item, as published on the /events stream
+type ChangeEvent struct {
+	Action Action // What happened, sent as the event name
+	ID     string // The key of the changed item
+	Data   any    // Optional payload, sent as JSON
+}
+
+// RegisterAPI registers the routes of genericApi under api.
+// It can be called several times with the same Api on different routers, each mount gets its own handlers and
+// any state they keep is created per registration, so nothing leaks between mounts.
+func RegisterAPI[T any, D any](api fiber.Router, genericApi Api[T, D]) {
+	genericApi.logf(slog.LevelInfo, "Registering REST api %s\n", genericApi.Path)
+	if hooks := genericApi.dtoHooks(); len(hooks) > 1 {
+		panic(fmt.Sprintf("api %s sets more than one Dto variant: %s", genericApi.Path, strings.Join(hooks, ", ")))
+	}
+	genericApi.mount = &mount{limiters: map[Action]*limiter{}}
+	for action, limit := range genericApi.RateLimits {
+		genericApi.mount.limiters[action] = newLimiter(limit)
+	}
+
+	// The api path, under its version if set
+	generic := api.Group(genericApi.prefix())
+	if genericApi.APIVersion != "" {
+		generic.Use(scoped(func(c *fiber.Ctx) error {
+			c.Set(HeaderAPIVersion, genericApi.APIVersion)
+			return c.Next()
+		}))
+	}
+
+	routes := genericApi.routes()
+	if genericApi.CORS != nil {
+		generic.Use(scoped(genericApi.CORS.cors(routes)))
+	}
+	for _, r := range routes {
+		handlers := slices.Clone(genericApi.Middleware[r.action])
+		handlers = append(handlers, genericApi.wrap(r.action, r.handler))
+		generic.Add(r.method, r.path, handlers...)
+	}
+	if genericApi.GroupFallback {
+		// Registered last, so only paths no route matched reach it
+		generic.Use(scoped(func(c *fiber.Ctx) error {
+			return genericApi.sendJSONError(c, fiber.StatusNotFound)
+		}))
+	}
+}
+
+// HeaderAPIVersion is the response header carrying the APIVersion
+const HeaderAPIVersion = "X-API-Version"
+
+// prefix is the path the Api is mounted at under its router, /APIVersion/Path
+func (api Api[T, D]) prefix() string {
+	if api.APIVersion != "" {
+		return "/" + api.APIVersion + "/" + api.Path
+	}
+	return "/" + api.Path
+}
+
+// scoped runs handler, used on a group, only for the group prefix and the paths below it, passing any other request on.
+// Use matches by string prefix, so the middleware of /items would otherwise also run for a sibling /items2.
+func scoped(handler fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		path, prefix := c.Path(), strings.TrimSuffix(c.Route().Path, "/")
+		if !c.App().Config().CaseSensitive {
+			path, prefix = strings.ToLower(path), strings.ToLower(prefix)
+		}
+		if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+			return c.Next()
+		}
+		return handler(c)
+	}
+}
+
+// route is a single route of an Api
+type route struct {
+	method  string
+	path    string
+	action  Action
+	handler fiber.Handler
+}
+
+// routes lists the routes exposed by the Api in registration order
+func (api Api[T, D]) routes() []route {
+	var routes []route
+	add := func(method string, path string, action Action, handler fiber.Handler) {
+		routes = append(routes, route{method: method, path: path, action: action, handler: handler})
+	}
+	// The two variants of GetAll
+	// Paging is synthesized from FindAll if FindAllPage is not provided
+	add(fiber.MethodGet, "/", ActionGetAll, getAll[T, D](api))
+	if api.FindAllPageSized != nil || api.FindAllPage != nil || api.FindAll != nil || api.FindAllCtx != nil {
+		add(fiber.MethodGet, "/page/:id", ActionGetAll, getAllPage[T, D](api))
+	}
+	// The POST create  (if provided)
+	if api.CreateMultipart != nil {
+		add(fiber.MethodPost, "/", ActionCreate, createMultipart[T, D](api))
+	} else if api.Create != nil || api.CreateCtx != nil || api.CreateWithID != nil || api.CreateWithGeneratedID != nil {
+		add(fiber.MethodPost, "/", ActionCreate, createOne[T, D](api))
+
+	}
+
+	// The DELETE of the collection (if provided)
+	if api.DeleteAll != nil {
+		add(fiber.MethodDelete, "/", ActionDeleteAll, deleteAll[T, D](api))
+	}
+
+	// The POST search  (if provided)
+	// A paged and sorted SearchQuery takes precedence over the plain Search
+	if api.SearchQuery != nil {
+		add(fiber.MethodPost, "/filter", ActionGetAll, searchQuery[T, D](api))
+	} else if api.Search != nil || api.SearchCtx != nil || api.SearchBool != nil {
+		add(fiber.MethodPost, "/filter", ActionGetAll, search[T, D](api))
+
+	}
+	// The GET search, with the filter bound from the query string (if provided)
+	if api.Search != nil || api.SearchCtx != nil {
+		add(fiber.MethodGet, "/filter", ActionGetAll, search[T, D](api))
+	}
+
+	// The POST find by filter (if provided)
+	if api.FindByFilter != nil {
+		add(fiber.MethodPost, "/find", ActionGetOne, findByFilter[T, D](api))
+	}
+
+	// The bulk get
+	add(fiber.MethodPost, "/mget", ActionGetOne, multiGet[T, D](api))
+
+	// The batch create and delete (if provided), processed synchronously unless enqueued with EnqueueBatch
+	if api.EnqueueBatch != nil || api.Create != nil || api.CreateCtx != nil {
+		add(fiber.MethodPost, "/batch", ActionCreate, batchCreate[T, D](api))
+	}
+	if api.EnqueueBatch != nil || (api.FindByFilter != nil && (api.Delete != nil || api.DeleteCtx != nil)) {
+		add(fiber.MethodDelete, "/batch", ActionDelete, batchDelete[T, D](api))
+	}
+
+	// The status of enqueued jobs (if provided)
+	// This is the one jobs route, shared by everything that enqueues work
+	if api.JobStatus != nil {
+		add(fiber.MethodGet, "/jobs/:jobId", ActionGetAll, jobStatus[T, D](api))
+	}
+
+	// The streamed export (if enabled)
+	if api.AllowExport {
+		add(fiber.MethodGet, "/export", ActionGetAll, exportAll[T, D](api))
+	}
+
+	// The trash of soft deleted items (if provided)
+	if api.FindDeleted != nil {
+		add(fiber.MethodGet, "/trash", ActionViewTrash, getTrash[T, D](api))
+	}
+
+	// The change events stream (if provided)
+	if api.Subscribe != nil {
+		add(fiber.MethodGet, "/events", ActionGetAll, streamEvents[T, D](api))
+	}
+
+	// The DTO schema (if enabled)
+	if api.ExposeSchema {
+		add(fiber.MethodGet, "/schema", ActionGetAll, getSchema[T, D](api))
+	}
+
+	// The capabilities metadata
+	add(fiber.MethodGet, "/_meta", ActionGetAll, getMeta[T, D](api))
+
+	// The possible values of enum like fields
+	add(fiber.MethodGet, "/_enums", ActionGetAll, getEnums[T, D](api))
+	add(fiber.MethodGet, "/_enums/:field", ActionGetAll, getEnums[T, D](api))
+
+	// The group by aggregates
+	for _, group := range api.GroupBy {
+		add(fiber.MethodGet, "/group/"+group.SubPath, ActionGetAll, groupBy[T, D](api, group))
+	}
+
+	// The secondary index lookups (if provided)
+	if len(api.Indexes) > 0 && (api.FindAll != nil || api.FindAllCtx != nil) {
+		add(fiber.MethodGet, "/by/:field/:value", ActionGetAll, getByIndex[T, D](api))
+	}
+
+	// The SubEntity getters
+	// This is before the item Getter to ensure any name collision resolves to the SubEntity
+	for _, subEntity := range api.SubEntities {
+		if subEntity.Disabled {
+			continue
+		}
+		add(fiber.MethodGet, "/:id/"+subEntity.SubPath, ActionGetOne, getSubEntity[T, D](api, subEntity))
+		if subEntity.FindOne != nil {
+			add(fiber.MethodGet, "/:id/"+subEntity.SubPath+"/:subId", ActionGetOne, getSubEntityOne[T, D](api, subEntity))
+		}
+		if subEntity.Delete != nil {
+			add(fiber.MethodDelete, "/:id/"+subEntity.SubPath+"/:subId", ActionDelete, deleteSubEntityOne[T, D](api, subEntity))
+		}
+	}
+
+	// The breadcrumb of a hierarchical item (if provided)
+	if api.Ancestors != nil {
+		add(fiber.MethodGet, "/:id/ancestors", ActionGetOne, getAncestors[T, D](api))
+	}
+
+	// The custom actions
+	for _, action := range api.CustomActions {
+		add(action.Method, "/:id/"+action.SubPath, action.Action, customAction[T, D](api, action))
+	}
+
+	// The Single item Getter
+	add(fiber.MethodGet, "/:id", ActionGetOne, getOne[T, D](api))
+
+	// The PUT mutation (if provided)
+	if api.Mutate != nil || api.MutateCtx != nil {
+		add(fiber.MethodPut, "/:id", ActionMutate, mutateOne[T, D](api))
+
+	}
+
+	// The PATCH mutation (if provided)
+	if api.ApplyPatch != nil {
+		add(fiber.MethodPatch, "/:id", ActionMutate, patchOne[T, D](api))
+	}
+
+	// The GET mutation (if provided)
+	if api.Delete != nil || api.DeleteCtx != nil || api.DeleteN != nil {
+		add(fiber.MethodDelete, "/:id", ActionDelete, deleteOne[T, D](api))
+
+	}
+
+	return routes
+}
+
+// wrap wraps the handler of a route for action with the cross-cutting concerns configured on the Api
+func (api Api[T, D]) wrap(action Action, handler fiber.Handler) fiber.Handler {
+	if api.RecoverPanics {
+		handler = api.recoverPanics(action, handler)
+	}
+	if api.SlowThreshold > 0 {
+		next := handler
+		handler = func(c *fiber.Ctx) error {
+			c.Locals(actionKey, action)
+			return next(c)
+		}
+	}
+	if api.CaptureFailed != nil {
+		handler = api.captureFailed(handler)
+	}
+	if (api.CacheTTL > 0 || len(api.Indexes) > 0) && action.isWrite() {
+		handler = api.invalidate(handler)
+	}
+	if api.Draining != nil && action.isWrite() {
+		handler = api.rejectDraining(handler)
+	}
+	if limiter := api.mount.limiter(action); limiter != nil {
+		handler = api.rateLimit(limiter, handler)
+	}
+	if api.StatusMapper != nil {
+		handler = api.mapStatus(action, handler)
+	}
+	if api.EmitGRPCStatus {
+		handler = emitGRPCStatus(handler)
+	}
+	if api.Tracer != nil {
+		handler = api.trace(action, handler)
+	}
+	return handler
+}
+
+// drainingRetryAfter is the Retry-After, in seconds, of the writes rejected while Draining
+const drainingRetryAfter = "5"
+
+// rejectDraining answers the writes handler would serve with 503 (Service Unavailable) for as long as the Api is Draining
+func (api Api[T, D]) rejectDraining(handler fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if api.Draining() {
+			c.Set(fiber.HeaderRetryAfter, drainingRetryAfter)
+			return api.sendError(c, fiber.StatusServiceUnavailable, nil)
+		}
+		return handler(c)
+	}
+}
+
+// trace runs handler in a Span
+func (api Api[T, D]) trace(action Action, handler fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, span := api.Tracer.Start(c, action.String()+" "+c.Route().Path)
+		defer span.End()
+		c.SetUserContext(ctx)
+		c.Locals(spanKey, span)
+		span.SetAttribute("resource", api.Path)
+		span.SetAttribute("action", action.String())
+		if id := c.Params("id"); id != "" {
+			span.SetAttribute("item.id", id)
+		}
+
+		err := handler(c)
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.SetStatus(c.Response().StatusCode())
+		return err
+	}
+}
+
+// mapStatus remaps the status handler responded with using the StatusMapper.
+// An error handler returns is left to the error handler with the mapped status, as a *fiber.Error when remapped.
+func (api Api[T, D]) mapStatus(action Action, handler fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := handler(c)
+		status := responseStatus(c, err)
+		mapped := api.StatusMapper(action, status)
+		switch {
+		case mapped == status:
+		case err != nil:
+			return fiber.NewError(mapped, err.Error())
+		default:
+			c.Status(mapped)
+		}
+		return err
+	}
+}
+
+// responseStatus is the status of the response to c once a handler returned err.
+// The error handler answers an error, with 500 unless it carries a status.
+func responseStatus(c *fiber.Ctx, err error) int {
+	if err == nil {
+		return c.Response().StatusCode()
+	}
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return fiberErr.Code
+	}
+	return fiber.StatusInternalServerError
+}
+
+// recoverPanics turns a panic in handler, e.g. in a user supplied Dto, into a logged 500 with a JSON body
+func (api Api[T, D]) recoverPanics(action Action, handler fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				api.logf(slog.LevelError, "Panic in %s %s: %v\n%s", action, c.Path(), r, debug.Stack())
+				resetResponse(c)
+				err = api.sendJSONError(c, fiber.StatusInternalServerError)
+			}
+		}()
+		return handler(c)
+	}
+}
+
+// resetResponse drops the body and the headers a handler set, such as an ETag or a Location that would be wrong
+// on an error, keeping those of the CORS and the APIVersion, which describe the resource rather than the response
+func resetResponse(c *fiber.Ctx) {
+	var kept [][2]string
+	c.Response().Header.VisitAll(func(key, value []byte) {
+		name := string(key)
+		if strings.HasPrefix(name, "Access-Control-") || name == fiber.HeaderVary || strings.EqualFold(name, HeaderAPIVersion) {
+			kept = append(kept, [2]string{name, string(value)})
+		}
+	})
+	c.Response().ResetBody()
+	c.Response().Header.Reset()
+	for _, header := range kept {
+		c.Response().Header.Add(header[0], header[1])
+	}
+}
+
+// sendJSONError answers status with a json body, problem details if enabled, otherwise {"error": message}
+func (api Api[T, D]) sendJSONError(c *fiber.Ctx, status int) error {
+	if api.EnableProblemJSON {
+		return api.sendError(c, status, nil)
+	}
+	return api.sendJSON(c.Status(status), fiber.Map{"error": utils.StatusMessage(status)}, api.contentType())
+}
+
+// logf logs through the Logger at level, or through the standard log package if no Logger is set
+func (api Api[T, D]) logf(level slog.Level, format string, args ...any) {
+	if api.Logger == nil {
+		log.Printf(format, args...)
+		return
+	}
+	api.Logger.Log(context.Background(), level, strings.TrimSuffix(fmt.Sprintf(format, args...), "\n"))
+}
+
+// Clone returns a copy of the Api that can be altered, e.g. to mount a variant, without affecting the original.
+// The function fields are shared, slices and maps such as SubEntities and Middleware are copied.
+func (api Api[T, D]) Clone() Api[T, D] {
+	clone := api
+	clone.SubEntities = slices.Clone(api.SubEntities)
+	clone.CustomActions = slices.Clone(api.CustomActions)
+	clone.GroupBy = slices.Clone(api.GroupBy)
+	clone.SensitiveFields = slices.Clone(api.SensitiveFields)
+	clone.SortableFields = slices.Clone(api.SortableFields)
+	clone.Middleware = maps.Clone(api.Middleware)
+	clone.RateLimits = maps.Clone(api.RateLimits)
+	clone.EnumValues = maps.Clone(api.EnumValues)
+	clone.ImmutableFields = slices.Clone(api.ImmutableFields)
+	clone.Indexes = maps.Clone(api.Indexes)
+	clone.AllowedFilterFields = slices.Clone(api.AllowedFilterFields)
+	return clone
+}
+
+// context returns the context handed to the data functions of a request, the user context carrying any trace span,
+// bounded by the Timeout if set.
+func (api Api[T, D]) context(c *fiber.Ctx) (context.Context, context.CancelFunc) {
+	ctx := c.UserContext()
+	if api.SlowThreshold > 0 {
+		action, _ := c.Locals(actionKey).(Action)
+		ctx = context.WithValue(ctx, slowKey{}, slowRequest{action: action, path: c.Path()})
+	}
+	if api.Timeout > 0 {
+		return context.WithTimeout(ctx, api.Timeout)
+	}
+	return ctx, func() {}
+}
+
+// actionKey is the fiber.Ctx local holding the Action of the request, when SlowThreshold needs it
+const actionKey = "easyrest.action"
+
+// slowKey is the context key of the slowRequest a data function is called for
+type slowKey struct{}
+
+// slowRequest describes the request of a data function call in the slow log
+type slowRequest struct {
+	action Action
+	path   string
+}
+
+// logSlow logs a warning if the data function fn, called with ctx, took longer than the SlowThreshold since start
+func (api Api[T, D]) logSlow(ctx context.Context, fn string, start time.Time) {
+	if api.SlowThreshold <= 0 {
+		return
+	}
+	if elapsed := time.Since(start); elapsed > api.SlowThreshold {
+		request, _ := ctx.Value(slowKey{}).(slowRequest)
+		api.logf(slog.LevelWarn, "Slow %s in %s %s: %v\n", fn, request.action, request.path, elapsed)
+	}
+}
+
+// timedOut reports whether the request deadline passed while doing the data work.
+func timedOut(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.DeadlineExceeded)
+}
+
+// find uses FindCtx if provided, then FindKey, otherwise Find.
+// An error is only returned for a malformed key.
+func (api Api[T, D]) find(ctx context.Context, key string) (T, bool, error) {
+	defer api.logSlow(ctx, "Find", time.Now())
+	if api.FindCtx != nil {
+		item, ok := api.FindCtx(ctx, key)
+		return item, ok, nil
+	}
+	if api.FindKey != nil {
+		return api.FindKey(key)
+	}
+	item, ok := api.Find(key)
+	return item, ok, nil
+}
+
+// TypedFind adapts a finder taking a strongly typed key K into a FindKey.
+// parse converts the path key to K, a parse error is answered with 400 (Bad Request).
+func TypedFind[T any, K any](parse func(string) (K, error), find func(K) (T, bool)) func(key string) (T, bool, error) {
+	return func(key string) (T, bool, error) {
+		k, err := parse(key)
+		if err != nil {
+			var empty T
+			return empty, false, err
+		}
+		item, ok := find(k)
+		return item, ok, nil
+	}
+}
+
+// findAll uses FindAllCtx if provided, otherwise FindAll
+func (api Api[T, D]) findAll(ctx context.Context) []T {
+	defer api.logSlow(ctx, "FindAll", time.Now())
+	if api.FindAllCtx != nil {
+		return api.FindAllCtx(ctx)
+	}
+	return api.FindAll()
+}
+
+// search uses SearchCtx if provided, otherwise Search
+func (api Api[T, D]) search(ctx context.Context, filter D) []T {
+	defer api.logSlow(ctx, "Search", time.Now())
+	if api.SearchCtx != nil {
+		return api.SearchCtx(ctx, filter)
+	}
+	return api.Search(filter)
+}
+
+// mutate uses MutateCtx if provided, otherwise Mutate
+func (api Api[T, D]) mutate(ctx context.Context, item T, edit D) (T, error) {
+	defer api.logSlow(ctx, "Mutate", time.Now())
+	if api.MutateCtx != nil {
+		return api.MutateCtx(ctx, item, edit)
+	}
+	return api.Mutate(item, edit)
+}
+
+// create uses CreateCtx if provided, otherwise Create
+func (api Api[T, D]) create(ctx context.Context, edit D) (T, error) {
+	defer api.logSlow(ctx, "Create", time.Now())
+	if api.CreateCtx != nil {
+		return api.CreateCtx(ctx, edit)
+	}
+	return api.Create(edit)
+}
+
+// delete uses DeleteCtx if provided, otherwise Delete
+func (api Api[T, D]) delete(ctx context.Context, item T) (T, error) {
+	defer api.logSlow(ctx, "Delete", time.Now())
+	if api.DeleteCtx != nil {
+		return api.DeleteCtx(ctx, item)
+	}
+	return api.Delete(item)
+}
+
+// Problem is an RFC 7807 problem details body
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// sendError sends an error status, err supplies the detail when available.
+// If EnableProblemJSON is set the status is sent with a problem+json body, otherwise just the status is sent.
+func (api Api[T, D]) sendError(c *fiber.Ctx, status int, err error) error {
+	return api.sendErrorWith(c, status, err, nil)
+}
+
+// sendErrorWith sends an error status like sendError, with members describing the error further.
+// The members are the json body of the error, or extension members of the problem details if EnableProblemJSON is set.
+func (api Api[T, D]) sendErrorWith(c *fiber.Ctx, status int, err error, members map[string]any) error {
+	if err != nil {
+		recordError(c, err)
+	}
+	if !api.EnableProblemJSON {
+		if members == nil {
+			return c.SendStatus(status)
+		}
+		return api.sendJSON(c.Status(status), members, api.contentType())
+	}
+	problem := Problem{
+		Type:     "about:blank",
+		Title:    utils.StatusMessage(status),
+		Status:   status,
+		Instance: c.OriginalURL(),
+	}
+	if err != nil {
+		problem.Detail = err.Error()
+	}
+	if len(members) == 0 {
+		return api.sendJSON(c.Status(status), problem, MIMEApplicationProblemJSON)
+	}
+	b, err := json.Marshal(problem)
+	if err != nil {
+		return err
+	}
+	body := map[string]any{}
+	if err := json.Unmarshal(b, &body); err != nil {
+		return err
+	}
+	for name, value := range members {
+		body[name] = value
+	}
+	return api.sendJSON(c.Status(status), body, MIMEApplicationProblemJSON)
+}
+
+// sendItem sends dto, the Dto of item, as the JSON response of a single item action, adding its Links if set
+func (api Api[T, D]) sendItem(c *fiber.Ctx, action Action, item T, dto D) error {
+	return api.sendItemWith(c, action, item, dto, nil)
+}
+
+// sendItemWith is sendItem merging the extra fields, such as _changes, into the json object of dto
+func (api Api[T, D]) sendItemWith(c *fiber.Ctx, action Action, item T, dto D, extra map[string]any) error {
+	if api.Links != nil {
+		extra = maps.Clone(extra)
+		if extra == nil {
+			extra = map[string]any{}
+		}
+		extra["_links"] = api.Links(item)
+	}
+	if len(extra) == 0 {
+		return api.send(c, action, dto)
+	}
+	return api.send(c, action, api.withFields(dto, extra))
+}
+
+// withFields merges extra into the json object of dto.
+// A dto that is not a json object is returned unchanged.
+func (api Api[T, D]) withFields(dto D, extra map[string]any) any {
+	fields, ok := api.jsonObject(dto)
+	if !ok {
+		return dto
+	}
+	for name, value := range extra {
+		fields[name] = value
+	}
+	return fields
+}
+
+// jsonObject is the json of v as a map of its fields, false if v is not a json object
+func (api Api[T, D]) jsonObject(v any) (map[string]any, bool) {
+	b, err := api.marshal(v)
+	if err != nil {
+		return nil, false
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(b, &fields); err != nil || fields == nil {
+		return nil, false
+	}
+	return fields, true
+}
+
+// FieldChange is the old and new json value of a field changed by a mutation, as reported by ReturnDiff
+type FieldChange struct {
+	Old any `json:"old"`
+	New any `json:"new"`
+}
+
+// diffFields lists the fields whose values differ between the json objects before and after.
+// Fields only present on one side are changes from or to null.
+func diffFields(before map[string]any, after map[string]any) map[string]FieldChange {
+	changes := map[string]FieldChange{}
+	for name, old := range before {
+		if value := after[name]; !reflect.DeepEqual(old, value) {
+			changes[name] = FieldChange{Old: old, New: value}
+		}
+	}
+	for name, value := range after {
+		if _, ok := before[name]; !ok {
+			changes[name] = FieldChange{New: value}
+		}
+	}
+	return changes
+}
+
+// send sends body as the JSON response, after passing it through the ResponseInterceptor if set
+func (api Api[T, D]) send(c *fiber.Ctx, action Action, body any) error {
+	if api.ResponseInterceptor != nil {
+		body = api.ResponseInterceptor(c, action, body)
+	}
+	return api.sendJSON(c, body, api.contentType())
+}
+
+// contentType is the content type of the JSON responses, the ContentType if set
+func (api Api[T, D]) contentType() string {
+	if api.ContentType != "" {
+		return api.ContentType
+	}
+	return fiber.MIMEApplicationJSON
+}
+
+// sendJSON serializes body with Marshal if set, otherwise with Fiber's configured encoder
+func (api Api[T, D]) sendJSON(c *fiber.Ctx, body any, ctype string) error {
+	if api.Marshal == nil {
+		return c.JSON(body, ctype)
+	}
+	b, err := api.Marshal(body)
+	if err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, ctype)
+	return c.Send(b)
+}
+
+// marshal serializes v with Marshal if set, otherwise with encoding/json
+func (api Api[T, D]) marshal(v any) ([]byte, error) {
+	if api.Marshal != nil {
+		return api.Marshal(v)
+	}
+	return json.Marshal(v)
+}
+
+// sendDataError answers an error returned by a data function.
+// The ErrorMapper decides the status if it is set and returns non zero, otherwise a ValidationError is 400
+// with its fields in the body, ErrConflict is 409, ErrNotFound is 404, ErrOverloaded is 503 with any Retry-After
+// from an OverloadedError and anything else is 500.
+func (api Api[T, D]) sendDataError(c *fiber.Ctx, err error) error {
+	if api.ErrorMapper != nil {
+		if status := api.ErrorMapper(err); status != 0 {
+			return api.sendError(c, status, err)
+		}
+	}
+
+	var invalid *ValidationError
+	if errors.As(err, &invalid) {
+		return api.sendErrorWith(c, fiber.StatusBadRequest, err, fiber.Map{"errors": invalid.Fields})
+	}
+
+	var overloaded *OverloadedError
+	if errors.As(err, &overloaded) && overloaded.RetryAfter > 0 {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(overloaded.RetryAfter.Seconds()))))
+	}
+	if errors.Is(err, ErrConflict) {
+		return api.sendError(c, fiber.StatusConflict, err)
+	}
+	if errors.Is(err, ErrNotFound) {
+		return api.sendError(c, fiber.StatusNotFound, err)
+	}
+	if errors.Is(err, ErrOverloaded) {
+		return api.sendError(c, fiber.StatusServiceUnavailable, err)
+	}
+	return api.sendError(c, fiber.StatusInternalServerError, err)
+}
+
+// DefaultConsistencyHeader is the request header asking for strongly consistent reads when ConsistencyHeader is not set
+const DefaultConsistencyHeader = "Consistency"
+
+// Warning headers (RFC 7234) of the reads StaleWarnings flags
+const (
+	HeaderWarning  = "Warning"
+	WarningStale   = `110 - "Response is Stale"`
+	WarningReplica = `111 - "Response is from a Replica"`
+)
+
+// warn adds warning to the Warning header of the response if StaleWarnings is set
+func (api Api[T, D]) warn(c *fiber.Ctx, warning string) {
+	if api.StaleWarnings {
+		c.Append(HeaderWarning, warning)
+	}
+}
+
+// strongRead reports if the request asks for a strongly consistent read, i.e. not from a replica
+func (api Api[T, D]) strongRead(c *fiber.Ctx) bool {
+	header := api.ConsistencyHeader
+	if header == "" {
+		header = DefaultConsistencyHeader
+	}
+	return strings.EqualFold(c.Get(header), "strong")
+}
+
+// parseBody parses the request body into out.
+// If the body cannot be accepted, the error response has been sent and done is true.
+func (api Api[T, D]) parseBody(c *fiber.Ctx, out any) (done bool, err error) {
+	if api.bodyTooLarge(c) {
+		return true, api.sendError(c, fiber.StatusRequestEntityTooLarge, nil)
+	}
+	if api.RequireJSONContentType && !isJSON(c.Get(fiber.HeaderContentType)) {
+		return true, api.sendError(c, fiber.StatusUnsupportedMediaType, nil)
+	}
+	if api.PreprocessBody != nil {
+		body, err := api.PreprocessBody(c.Body())
+		if err != nil {
+			return true, api.sendError(c, fiber.StatusBadRequest, err)
+		}
+		c.Request().SetBody(body)
+	}
+	if err := c.BodyParser(out); err != nil {
+		api.logf(slog.LevelError, "Error parsing body %v\n", err)
+		return true, api.sendError(c, fiber.StatusBadRequest, err)
+	}
+	return false, nil
+}
+
+// bodyTooLarge reports if the request body exceeds MaxBodyBytes, as declared, as sent or once decoded.
+// The declared length and the body as sent are checked first, so an oversized body is never decompressed.
+func (api Api[T, D]) bodyTooLarge(c *fiber.Ctx) bool {
+	if api.MaxBodyBytes <= 0 {
+		return false
+	}
+	return c.Request().Header.ContentLength() > api.MaxBodyBytes ||
+		len(c.Request().Body()) > api.MaxBodyBytes ||
+		len(c.Body()) > api.MaxBodyBytes
+}
+
+// emptyBody reports if the request body is empty or only whitespace
+func emptyBody(c *fiber.Ctx) bool {
+	return len(bytes.TrimSpace(c.Body())) == 0
+}
+
+// isJSON reports if contentType is application/json, allowing parameters such as the charset
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == fiber.MIMEApplicationJSON
+}
+
+// findItem finds the item on the path and checks the caller may perform action on it.
+// If the item cannot be acted on, the error response has been sent and done is true.
+func (api Api[T, D]) findItem(c *fiber.Ctx, ctx context.Context, action Action) (item T, done bool, err error) {
+	var ok bool
+	switch {
+	case action == ActionGetOne && api.Singleflight && api.mount != nil:
+		item, ok, err = api.findShared(c, ctx)
+	case action == ActionGetOne && api.FindReplica != nil && !api.strongRead(c):
+		item, ok = api.FindReplica(c.Params("id"))
+		api.warn(c, WarningReplica)
+	default:
+		item, ok, err = api.find(ctx, c.Params("id"))
+	}
+	if timedOut(ctx) || errors.Is(err, context.DeadlineExceeded) {
+		return item, true, api.sendError(c, fiber.StatusGatewayTimeout, nil)
+	}
+	if err != nil {
+		return item, true, api.sendError(c, fiber.StatusBadRequest, err)
+	}
+	if status, err := api.checkItem(c, action, item, ok); status != 0 {
+		return item, true, api.sendCheckFailed(c, action, status, err)
+	}
+	return item, false, nil
+}
+
+// checkItem runs the checks every item level action makes of a looked up item, ok if it was found.
+// The item must be found and not IsZero, the AuthPolicy or Validator must allow action on it, consulted once,
+// and it must pass the OwnershipCheck.  It returns the status of the first failing check, 0 if all pass.
+func (api Api[T, D]) checkItem(c *fiber.Ctx, action Action, item T, ok bool) (status int, err error) {
+	if ok && api.IsZero != nil && api.IsZero(item) {
+		api.logf(slog.LevelWarn, "Find returned a zero item for %s\n", c.Params("id"))
+		ok = false
+	}
+	if !ok {
+		return fiber.StatusNotFound, nil
+	}
+	allowed, err := api.authorize(c, action, item)
+	if err != nil {
+		return fiber.StatusInternalServerError, err
+	}
+	if !allowed {
+		return api.deniedStatus(), nil
+	}
+	if api.OwnershipCheck != nil && !api.OwnershipCheck(c, item) {
+		return fiber.StatusForbidden, nil
+	}
+	return 0, nil
+}
+
+// sendCheckFailed answers an item level action for which checkItem failed with status
+func (api Api[T, D]) sendCheckFailed(c *fiber.Ctx, action Action, status int, err error) error {
+	if status == fiber.StatusNotFound {
+		return api.notFound(c, action)
+	}
+	return api.sendError(c, status, err)
+}
+
+// findShared finds the item on the path for a read, sharing the lookup with concurrent reads of the same id
+func (api Api[T, D]) findShared(c *fiber.Ctx, ctx context.Context) (T, bool, error) {
+	id := c.Params("id")
+	replica := api.FindReplica != nil && !api.strongRead(c)
+	key := id
+	if !replica {
+		// Strong reads do not share the lookups of replica reads
+		key = "strong:" + id
+	}
+	v, err, _ := api.mount.flight.Do(key, func() (any, error) {
+		if replica {
+			item, ok := api.FindReplica(id)
+			return found[T]{item, ok}, nil
+		}
+		item, ok, err := api.find(ctx, id)
+		if timedOut(ctx) {
+			// Time out the requests sharing the lookup too
+			return nil, ctx.Err()
+		}
+		return found[T]{item, ok}, err
+	})
+	if replica {
+		api.warn(c, WarningReplica)
+	}
+	result, _ := v.(found[T])
+	return result.item, result.ok, err
+}
+
+// authorize reports if the caller may perform action, on item if there is one.
+// The AuthPolicy decides when set, otherwise the Validator; with neither everything is allowed.
+func (api Api[T, D]) authorize(c *fiber.Ctx, action Action, item ...T) (bool, error) {
+	if api.AuthPolicy != nil {
+		var resource any
+		if len(item) > 0 {
+			resource = item[0]
+		}
+		return api.AuthPolicy.Allow(c, action, resource)
+	}
+	return api.Validator == nil || api.Validator(c, action, item...), nil
+}
+
+// sendDenied answers a request authorize rejected with status, or with 500 if the policy failed to decide
+func (api Api[T, D]) sendDenied(c *fiber.Ctx, status int, err error) error {
+	if err != nil {
+		return api.sendError(c, fiber.StatusInternalServerError, err)
+	}
+	return api.sendError(c, status, nil)
+}
+
+// precedence is the effective NotFoundPrecedence, honouring DisableLeakProtection
+func (api Api[T, D]) precedence() NotFoundPrecedence {
+	if api.NotFoundPrecedence == PrecedenceLeak && api.DisableLeakProtection {
+		return PrecedenceAlwaysNotFoundFirst
+	}
+	return api.NotFoundPrecedence
+}
+
+// deniedStatus is the status of an item level action the Validator rejects
+func (api Api[T, D]) deniedStatus() int {
+	if api.precedence() == PrecedenceAlwaysForbidFirst {
+		return fiber.StatusForbidden
+	}
+	return fiber.StatusUnauthorized
+}
+
+// notFound answers a failed item lookup for action with 404.
+// Unless the precedence is PrecedenceAlwaysNotFoundFirst, a caller the Validator rejects is denied instead so that
+// the existence of items is not leaked to unauthorized callers.
+// An id IsGone reports as purged is 410 (Gone) instead, and with IdempotentDelete a delete is 204 (No Content).
+func (api Api[T, D]) notFound(c *fiber.Ctx, action Action) error {
+	if action == ActionDelete && api.IdempotentDelete {
+		// Deleting an absent item succeeds, as long as the caller may delete
+		if allowed, err := api.authorize(c, action); !allowed {
+			return api.sendDenied(c, api.deniedStatus(), err)
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+	if api.precedence() != PrecedenceAlwaysNotFoundFirst {
+		if allowed, err := api.authorize(c, action); !allowed {
+			return api.sendDenied(c, api.deniedStatus(), err)
+		}
+	}
+	if id := c.Params("id"); id != "" && api.IsGone != nil && api.IsGone(id) {
+		return api.sendError(c, fiber.StatusGone, nil)
+	}
+	return api.sendError(c, fiber.StatusNotFound, nil)
+}
+
+// MIMEApplicationProblemJSON is the content type of RFC 7807 problem details
+const MIMEApplicationProblemJSON = "application/problem+json"
+
+// getAll returns all entities as their Jdo type
+func getAll[T any, D any](api Api[T, D]) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Perms check
+		if allowed, err := api.authorize(c, ActionGetAll); !allowed {
+			return api.sendDenied(c, fiber.StatusUnauthorized, err)
+		}
+
+		// A cheap collection version avoids even finding the items
+		if api.EnableCollectionETag && api.CollectionVersion != nil {
+			etag := entityTag(api.CollectionVersion(), false)
+			c.Set(fiber.HeaderETag, etag)
+			if weakMatch(c.Get(fiber.HeaderIfNoneMatch), etag) {
+				return c.SendStatus(fiber.StatusNotModified)
+			}
+		}
+
+		// Find all
+		// Transform to DTO
+		// Send as JSON
+		ctx, cancel := api.context(c)
+		defer cancel()
+		var found []T
+		if api.FindAllReplica != nil && !api.strongRead(c) {
+			found = api.FindAllReplica()
+			api.warn(c, WarningReplica)
+		} else {
+			found = api.findAll(ctx)
+		}
+		if timedOut(ctx) {
+			return api.sendError(c, fiber.StatusGatewayTimeout, nil)
+		}
+
+		// Apply any offset/limit window, reporting it in the headers
+		if c.Query("offset") != "" || c.Query("limit") != "" {
+			offset, limit, err := window(c, len(found))
+			if err != nil {
+				return api.sendError(c, fiber.StatusBadRequest, err)
+			}
+			c.Set(HeaderTotalCount, strconv.Itoa(len(found)))
+			c.Set(HeaderOffset, strconv.Itoa(offset))
+			c.Set(HeaderLimit, strconv.Itoa(limit))
+			// The window is clamped before adding up so a huge limit cannot overflow
+			start := min(offset, len(found))
+			found = found[start : start+min(limit, len(found)-start)]
+		}
+
+		if api.wantsNDJSON(c) {
+			// The stream outlives c, so the items are localized up front
+			var locale string
+			if api.DtoLocalized != nil {
+				locale = api.locale(c)
+			}
+			return api.streamNDJSON(c, len(found), func(i int) (any, error) {
+				if api.DtoLocalized != nil {
+					return api.DtoLocalized(found[i], locale), nil
+				}
+				return api.dto(found[i])
+			})
+		}
+
+		all, err := api.dtoAll(c, found)
+		if err != nil {
+			return api.sendDataError(c, err)
+		}
+
+		// Otherwise the ETag is a hash of the collection.
+		// Serialization may vary, e.g. in order, so the hash is only a weak validator.
+		if api.EnableCollectionETag && api.CollectionVersion == nil {
+			b, err := api.marshal(all)
+			if err != nil {
+				return err
+			}
+			h := fnv.New64a()
+			h.Write(b)
+			etag := entityTag(strconv.FormatUint(h.Sum64(), 16), true)
+			c.Set(fiber.HeaderETag, etag)
+			if weakMatch(c.Get(fiber.HeaderIfNoneMatch), etag) {
+				return c.SendStatus(fiber.StatusNotModified)
+			}
+		}
+		return api.send(c, ActionGetAll, all)
+	}
+}
+
+// Headers describing the window of a limited collection
+const (
+	HeaderTotalCount = "X-Total-Count"
+	HeaderOffset     = "X-Offset"
+	HeaderLimit      = "X-Limit"
+)
+
+// HeaderResultsTruncated is set on search responses cut short at MaxSearchResults
+const HeaderResultsTruncated = "X-Results-Truncated"
+
+// window parses the offset and limit query parameters, the limit defaults to the rest of the total
+func window(c *fiber.Ctx, total int) (offset int, limit int, err error) {
+	offset, err = strconv.Atoi(c.Query("offset", "0"))
+	if err != nil || offset < 0 {
+		return 0, 0, errors.New("offset must be a non negative integer")
+	}
+	limit, err = strconv.Atoi(c.Query("limit", strconv.Itoa(max(total-offset, 0))))
+	if err != nil || limit < 0 {
+		return 0, 0, errors.New("limit must be a non negative integer")
+	}
+	return offset, limit, nil
+}
+func getAllPage[T any, D any](api Api[T, D]) fiber.Handler {
+	handler := func(c *fiber.Ctx) error {
+		// Perms check, before the parameters so that a denied caller learns nothing from their validation
+		if allowed, err := api.authorize(c, ActionGetAll); !allowed {
+			return api.sendDenied(c, fiber.StatusUnauthorized, err)
+		}
+
+		// Validate the page number
+		id := c.Params("id")
+		i, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return api.sendError(c, fiber.StatusBadRequest, errors.New("page must be an integer"))
+		}
+		if i < 1 {
+			return api.sendError(c, fiber.StatusBadRequest, errors.New("page must be at least 1"))
+		}
+		if api.MaxPage > 0 && i > api.MaxPage {
+			if !api.ClampPage {
+				return api.sendError(c, fiber.StatusBadRequest, fmt.Errorf("page must not exceed %d", api.MaxPage))
+			}
+			i = api.MaxPage
+		}
+
+		// Find the page
+		// Transform to DTO
+		// Send as JSON
+		// The size is only read where it is honoured, FindAllPage pages by its own size
+		var size int
+		if api.FindAllPageSized != nil || api.FindAllPage == nil {
+			if size, err = api.pageSize(c); err != nil {
+				return api.sendError(c, fiber.StatusBadRequest, err)
+			}
+		}
+		var page Page[T]
+		switch {
+		case api.FindAllPageSized != nil:
+			page = api.FindAllPageSized(i, size)
+		case api.FindAllPage != nil:
+			page = api.FindAllPage(i)
+		default:
+			// Synthesize the page from all the items
+			ctx, cancel := api.context(c)
+			defer cancel()
+			found := api.findAll(ctx)
+			if timedOut(ctx) {
+				return api.sendError(c, fiber.StatusGatewayTimeout, nil)
+			}
+			page = pageOf(found, i, int64(size))
+		}
+
+		all, err := api.dtoPage(c, page)
+		if err != nil {
+			return api.sendDataError(c, err)
+		}
+		c.Set(fiber.HeaderLink, pageLinks(c, all.CurrentPage, all.Pages))
+		return api.send(c, ActionGetAll, api.renderPage(all))
+
+	}
+	if api.cacheable() {
+		return api.cachePages(handler)
+	}
+	return handler
+}
+
+// defaultMaxPageSize caps the requested page size when MaxPageSize is not set, as Paginate does
+const defaultMaxPageSize = 10000
+
+// pageSize reads the requested page size from the size query parameter, clamped to the MaxPageSizeFor the caller
+// or the MaxPageSize.
+// Without the parameter the DefaultPageSize is used.
+func (api Api[T, D]) pageSize(c *fiber.Ctx) (int, error) {
+	size := api.DefaultPageSize
+	if size <= 0 {
+		size = 10
+	}
+	if q := c.Query("size"); q != "" {
+		n, err := strconv.Atoi(q)
+		if err != nil || n < 1 {
+			return 0, errors.New("size must be a positive integer")
+		}
+		size = n
+	}
+	maxSize := api.MaxPageSize
+	if api.MaxPageSizeFor != nil {
+		if callerMax := api.MaxPageSizeFor(c); callerMax > 0 {
+			maxSize = callerMax
+		}
+	}
+	if maxSize <= 0 {
+		maxSize = defaultMaxPageSize
+	}
+	return min(size, maxSize), nil
+}
+
+// HeaderCreatedAt carries the creation time of an item, in RFC 3339 format
+const HeaderCreatedAt = "X-Created-At"
+
+// setTimestamps sets the Last-Modified and X-Created-At headers of item, where configured
+func (api Api[T, D]) setTimestamps(c *fiber.Ctx, item T) {
+	if api.LastModified != nil {
+		c.Set(fiber.HeaderLastModified, api.LastModified(item).UTC().Format(http.TimeFormat))
+	}
+	if api.CreatedAt != nil {
+		c.Set(HeaderCreatedAt, api.CreatedAt(item).UTC().Format(time.RFC3339))
+	}
+}
+
+// enrich augments an item found for c with Enrich, if set
+func (api Api[T, D]) enrich(c *fiber.Ctx, item T) T {
+	if api.Enrich != nil {
+		return api.Enrich(c, item)
+	}
+	return item
+}
+
+// dtoHooks lists the Dto variants set in place of Dto: DtoWithOpts, DtoWithIncludes, DtoLocalized, DtoE and DtoBatch.
+// They do not compose, so RegisterAPI panics if more than one is set
+func (api Api[T, D]) dtoHooks() []string {
+	var hooks []string
+	for name, set := range map[string]bool{
+		"DtoWithOpts":     api.DtoWithOpts != nil,
+		"DtoWithIncludes": api.DtoWithIncludes != nil,
+		"DtoLocalized":    api.DtoLocalized != nil,
+		"DtoE":            api.DtoE != nil,
+		"DtoBatch":        api.DtoBatch != nil,
+	} {
+		if set {
+			hooks = append(hooks, name)
+		}
+	}
+	slices.Sort(hooks)
+	return hooks
+}
+
+// dto transforms item to its Dto with DtoE if set, otherwise Dto
+func (api Api[T, D]) dto(item T) (D, error) {
+	if api.DtoE != nil {
+		return api.DtoE(item)
+	}
+	return api.Dto(item), nil
+}
+
+// dtoOne transforms a single item requested by c to its Dto, enriching it first and using DtoWithOpts if set
+func (api Api[T, D]) dtoOne(c *fiber.Ctx, item T) (D, error) {
+	item = api.enrich(c, item)
+	if api.DtoWithOpts != nil {
+		// A malformed query still yields the values that could be parsed
+		opts, _ := url.ParseQuery(string(c.Request().URI().QueryString()))
+		return api.DtoWithOpts(item, opts), nil
+	}
+	if api.DtoWithIncludes != nil {
+		return api.DtoWithIncludes(item, includes(c)), nil
+	}
+	return api.dtoLocalized(c, item)
+}
+
+// dtoLocalized transforms item to its Dto in the locale of the request with DtoLocalized if set, otherwise with dto
+func (api Api[T, D]) dtoLocalized(c *fiber.Ctx, item T) (D, error) {
+	if api.DtoLocalized != nil {
+		return api.DtoLocalized(item, api.locale(c)), nil
+	}
+	return api.dto(item)
+}
+
+// locale is the language range of the Accept-Language header of c with the highest quality,
+// or the DefaultLocale if it names none
+func (api Api[T, D]) locale(c *fiber.Ctx) string {
+	c.Vary(fiber.HeaderAcceptLanguage)
+	locale, best := api.DefaultLocale, 0.0
+	for _, language := range strings.Split(c.Get(fiber.HeaderAcceptLanguage), ",") {
+		tag, params, _ := strings.Cut(language, ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		if q > best {
+			locale, best = tag, q
+		}
+	}
+	return locale
+}
+
+// includes is the set of optional fields requested with ?include=, a comma separated list
+func includes(c *fiber.Ctx) map[string]bool {
+	set := map[string]bool{}
+	for _, field := range strings.Split(c.Query("include"), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			set[field] = true
+		}
+	}
+	return set
+}
+
+// dtoAll transforms the items of a collection requested by c to their Dto, enriching each first.
+// DtoBatch transforms them all at once if set, otherwise the first failing transform fails the whole collection.
+func (api Api[T, D]) dtoAll(c *fiber.Ctx, items []T) ([]D, error) {
+	if api.DtoBatch != nil {
+		if len(items) == 0 {
+			return nil, nil
+		}
+		enriched := make([]T, len(items))
+		for i, v := range items {
+			enriched[i] = api.enrich(c, v)
+		}
+		return api.DtoBatch(enriched), nil
+	}
+	var all []D
+	var included map[string]bool
+	if api.DtoWithIncludes != nil {
+		included = includes(c)
+	}
+	for _, v := range items {
+		if included != nil {
+			all = append(all, api.DtoWithIncludes(api.enrich(c, v), included))
+			continue
+		}
+		d, err := api.dtoLocalized(c, api.enrich(c, v))
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, d)
+	}
+	return all, nil
+}
+
+// dtoPage transforms the items of a page requested by c to their Dto
+func (api Api[T, D]) dtoPage(c *fiber.Ctx, page Page[T]) (Page[D], error) {
+	data, err := api.dtoAll(c, page.Data)
+	if err != nil {
+		return Page[D]{}, err
+	}
+	all := Page[D]{
+		CurrentPage: page.CurrentPage,
+		PageSize:    page.PageSize,
+		Total:       page.Total,
+		Pages:       page.Pages,
+		Data:        data,
+	}
+	if all.Data == nil {
+		all.Data = []D{}
+	}
+	return all, nil
+}
+
+// renderPage shapes page for the response with the PageRenderer if set
+func (api Api[T, D]) renderPage(page Page[D]) any {
+	if api.PageRenderer == nil {
+		return page
+	}
+	return api.PageRenderer(page)
+}
+
+// pageOf slices page current of size out of all the items, with the same bounds as Paginate
+func pageOf[T any](all []T, current int64, size int64) Page[T] {
+	page := Page[T]{CurrentPage: current, PageSize: size, Total: int64(len(all))}
+	if page.CurrentPage <= 0 {
+		page.CurrentPage = 1
+	}
+	if page.PageSize <= 0 {
+		page.PageSize = 10
+	}
+	page.Pages = (page.Total + page.PageSize - 1) / page.PageSize
+
+	start := (page.CurrentPage - 1) * page.PageSize
+	if start >= page.Total {
+		page.Data = []T{}
+		return page
+	}
+	end := min(start+page.PageSize, page.Total)
+	page.Data = all[start:end]
+	return page
+}
+
+// pageLinks builds an RFC 5988 Link header value for the page endpoint from the page metadata.
+// The links reuse the request path (and query) with the page number replaced.
+// next and prev are omitted on the boundary pages.
+func pageLinks(c *fiber.Ctx, current int64, pages int64) string {
+	path := c.Path()
+	base := c.BaseURL() + path[:strings.LastIndex(path, "/")+1]
+	query := ""
+	if q := c.Request().URI().QueryString(); len(q) > 0 {
+		query = "?" + string(q)
+	}
+	if pages < 1 {
+		pages = 1
+	}
+	link := func(page int64, rel string) string {
+		return fmt.Sprintf(`<%s%d%s>; rel="%s"`, base, page, query, rel)
+	}
+
+	var links []string
+	if current < pages {
+		links = append(links, link(current+1, "next"))
+	}
+	if current > 1 {
+		links = append(links, link(current-1, "prev"))
+	}
+	links = append(links, link(1, "first"), link(pages, "last"))
+	return strings.Join(links, ", ")
+}
+
+// search returns the entities matching the filter in the body as their Jdo type,
+// the filter being a BoolQuery if SearchBool is set.  A GET binds the filter from the query string with BindFilter.
+// With SearchFacets a filter's results are sent along with their facet counts.
+func search[T any, D any](api Api[T, D]) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Perms check
+		if allowed, err := api.authorize(c, ActionGetAll); !allowed {
+			return api.sendDenied(c, fiber.StatusUnauthorized, err)
+		}
+
+		// Search with filter
+		// Transform to DTO
+		// Send as JSON
+		var found []T
+		var facets map[string]map[string]int
+		faceted := false
+		if c.Method() == fiber.MethodGet {
+			var emptyD D
+			if err := api.checkFilterFields(filteredFields(c, reflect.TypeOf(emptyD))); err != nil {
+				return api.sendDataError(c, err)
+			}
+			filter, err := BindFilter[D](c)
+			if err != nil {
+				return api.sendError(c, fiber.StatusBadRequest, err)
+			}
+
+			ctx, cancel := api.context(c)
+			defer cancel()
+			found = api.search(ctx, filter)
+			if timedOut(ctx) {
+				return api.sendError(c, fiber.StatusGatewayTimeout, nil)
+			}
+			if api.SearchFacets != nil {
+				facets, faceted = api.SearchFacets(filter), true
+			}
+		} else if api.SearchBool != nil {
+			var query BoolQuery[D]
+			if done, err := api.parseBody(c, &query); done {
+				return err
+			}
+			if len(query.And) == 0 && len(query.Or) == 0 {
+				return api.sendError(c, fiber.StatusBadRequest, errors.New("a boolean query needs and or or clauses"))
+			}
+			var raw struct {
+				And []map[string]any `json:"and"`
+				Or  []map[string]any `json:"or"`
+			}
+			if err := json.Unmarshal(c.Body(), &raw); err == nil {
+				var fields []string
+				for _, clause := range append(raw.And, raw.Or...) {
+					for field := range clause {
+						fields = append(fields, field)
+					}
+				}
+				if err := api.checkFilterFields(fields); err != nil {
+					return api.sendDataError(c, err)
+				}
+			}
+			found = api.SearchBool(query)
+		} else {
+			var filter D
+			if done, err := api.parseBody(c, &filter); done {
+				return err
+			}
+			if err := api.checkFilterFields(bodyFields(c.Body())); err != nil {
+				return api.sendDataError(c, err)
+			}
+
+			ctx, cancel := api.context(c)
+			defer cancel()
+			found = api.search(ctx, filter)
+			if timedOut(ctx) {
+				return api.sendError(c, fiber.StatusGatewayTimeout, nil)
+			}
+			if api.SearchFacets != nil {
+				facets, faceted = api.SearchFacets(filter), true
+			}
+		}
+
+		// Cap the results
+		if api.MaxSearchResults > 0 && len(found) > api.MaxSearchResults {
+			if !api.TruncateSearch {
+				return api.sendError(c, fiber.StatusUnprocessableEntity,
+					fmt.Errorf("more than %d results, narrow the filter", api.MaxSearchResults))
+			}
+			c.Set(HeaderResultsTruncated, "true")
+			found = found[:api.MaxSearchResults]
+		}
+		all, err := api.dtoAll(c, found)
+		if err != nil {
+			return api.sendDataError(c, err)
+		}
+		if faceted {
+			if all == nil {
+				all = []D{}
+			}
+			return api.send(c, ActionGetAll, fiber.Map{"data": all, "facets": facets})
+		}
+		return api.send(c, ActionGetAll, all)
+	}
+}
+
+// BoolQuery is a search combining filters, the body of POST /filter when SearchBool is set, e.g.
+//
+//	{"and": [<D>, <D>], "or": [<D>]}
+//
+// How the clauses combine, e.g. whether the And clauses must match as well as one of the Or clauses,
+// is up to SearchBool.
+type BoolQuery[D any] struct {
+	And []D `json:"and"`
+	Or  []D `json:"or"`
+}
+
+// UnmarshalJSON decodes a BoolQuery, rejecting anything but and and or arrays of filters
+func (q *BoolQuery[D]) UnmarshalJSON(b []byte) error {
+	var clauses map[string]json.RawMessage
+	if err := json.Unmarshal(b, &clauses); err != nil {
+		return err
+	}
+	for key, value := range clauses {
+		var filters []D
+		if err := json.Unmarshal(value, &filters); err != nil {
+			return fmt.Errorf("%s must be an array of filters: %w", key, err)
+		}
+		switch key {
+		case "and":
+			q.And = filters
+		case "or":
+			q.Or = filters
+		default:
+			return fmt.Errorf("unknown boolean clause %s", key)
+		}
+	}
+	return nil
+}
+
+// QueryOptions are the paging and sorting options of a SearchQuery.
+// They are read from the page, size and sort query parameters, sort being a comma separated list of fields.
+type QueryOptions struct {
+	Page int      // The page number, from 1
+	Size int      // The page size, clamped to MaxPageSize
+	Sort []string // The fields to sort by, in order, as supplied by the client
+}
+
+// bodyFields lists the fields of the json object body, none if it is not an object
+func bodyFields(body []byte) []string {
+	var object map[string]json.RawMessage
+	if json.Unmarshal(body, &object) != nil {
+		return nil
+	}
+	fields := make([]string, 0, len(object))
+	for field := range object {
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// checkFilterFields fails with a ValidationError naming those of the fields a filter sets that are not among the
+// AllowedFilterFields, nil if they are all allowed or there is no allowlist
+func (api Api[T, D]) checkFilterFields(fields []string) error {
+	if len(api.AllowedFilterFields) == 0 {
+		return nil
+	}
+	disallowed := map[string]string{}
+	for _, field := range fields {
+		if !slices.Contains(api.AllowedFilterFields, field) {
+			disallowed[field] = "filtering on this field is not allowed"
+		}
+	}
+	if len(disallowed) > 0 {
+		return &ValidationError{Fields: disallowed}
+	}
+	return nil
+}
+
+// searchQuery returns a page of the entities matching the filter in the body as their Dto type,
+// paged and sorted according to the query parameters
+func searchQuery[T any, D any](api Api[T, D]) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Perms check
+		if allowed, err := api.authorize(c, ActionGetAll); !allowed {
+			return api.sendDenied(c, fiber.StatusUnauthorized, err)
+		}
+
+		var filter D
+		if done, err := api.parseBody(c, &filter); done {
+			return err
+		}
+		if err := api.checkFilterFields(bodyFields(c.Body())); err != nil {
+			return api.sendDataError(c, err)
+		}
+
+		opts, err := api.queryOptions(c)
+		if err != nil {
+			return api.sendError(c, fiber.StatusBadRequest, err)
+		}
+		page, err := api.dtoPage(c, api.SearchQuery(filter, opts))
+		if err != nil {
+			return api.sendDataError(c, err)
+		}
+		return api.send(c, ActionGetAll, api.renderPage(page))
+	}
+}
+
+// queryOptions reads and validates the QueryOptions of a request
+func (api Api[T, D]) queryOptions(c *fiber.Ctx) (opts QueryOptions, err error) {
+	opts.Page, err = strconv.Atoi(c.Query("page", "1"))
+	if err != nil || opts.Page < 1 {
+		return opts, errors.New("page must be a positive integer")
+	}
+	if opts.Size, err = api.pageSize(c); err != nil {
+		return opts, err
+	}
+	for _, field := range strings.Split(c.Query("sort"), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			if len(api.SortableFields) > 0 && !slices.Contains(api.SortableFields, strings.TrimLeft(field, "+-")) {
+				return opts, fmt.Errorf("cannot sort by %s", field)
+			}
+			opts.Sort = append(opts.Sort, field)
+		}
+	}
+	return opts, nil
+}
+
+// findByFilter returns the Dto of the single item matching the filter in the body
+// 404 if no item matches
+func findByFilter[T any, D any](api Api[T, D]) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+
+		var filter D
+		if done, err := api.parseBody(c, &filter); done {
+			return err
+		}
+
+		item, ok := api.FindByFilter(filter)
+		if status, err := api.checkItem(c, ActionGetOne, item, ok); status != 0 {
+			return api.sendCheckFailed(c, ActionGetOne, status, err)
+		}
+		dto, err := api.dtoOne(c, item)
+		if err != nil {
+			return api.sendDataError(c, err)
+		}
+		return api.sendItem(c, ActionGetOne, item, dto)
+	}
+}
+
+// multiGetRequest is the body of a bulk get
+type multiGetRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// multiGet returns the Dto of each of the items with the ids in the body, as an array or an id keyed map.
+// Items that are missing or that fail the checks of a getOne are skipped.
+func multiGet[T any, D any](api Api[T, D]) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+
+		var req multiGetRequest
+		if done, err := api.parseBody(c, &req); done {
+			return err
+		}
+		if done, err := api.batchTooLarge(c, len(req.IDs)); done {
+			return err
+		}
+
+		ctx, cancel := api.context(c)
+		defer cancel()
+		all := []D{}
+		byID := map[string]D{}
+		for _, id := range req.IDs {
+			item, ok, err := api.find(ctx, id)
+			if timedOut(ctx) {
+				return api.sendError(c, fiber.StatusGatewayTimeout, nil)
+			}
+			if err != nil {
+				continue
+			}
+			status, err := api.checkItem(c, ActionGetOne, item, ok)
+			if status == fiber.StatusInternalServerError {
+				return api.sendError(c, status, err)
+			}
+			if status != 0 {
+				continue
+			}
+			dto, err := api.dtoOne(c, item)
+			if err != nil {
+				return api.sendDataError(c, err)
+			}
+			if api.MGetAsMap {
+				byID[id] = dto
+			} else {
+				all = append(all, dto)
+			}
+		}
+
+		if api.MGetAsMap {
+			return api.send(c, ActionGetOne, byID)
+		}
+		return api.send(c, ActionGetOne, all)
+	}
+}
+
+// getOne returns a single Jdo for a single item on the path.
+// 404 if entity is not in the cache
+func getOne[T any, D any](api Api[T, D]) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+
+		// Redirect an alias to the canonical url of the item, only for a caller who may get items
+		// so the mapping of aliases to ids is not disclosed
+		if api.CanonicalID != nil {
+			if canonical, isAlias := api.CanonicalID(c.Params("id")); isAlias {
+				if allowed, err := api.authorize(c, ActionGetOne); !allowed {
+					return api.sendDenied(c, api.deniedStatus(), err)
+				}
+				location := c.Path()[:strings.LastIndex(c.Path(), "/")+1] + url.PathEscape(canonical)
+				if query := c.Request().URI().QueryString(); len(query) > 0 {
+					location += "?" + string(query)
+				}
+				return c.Redirect(location, fiber.StatusMovedPermanently)
+			}
+		}
+
+		embeds, err := api.embeds(c)
+		if err != nil {
+			return api.sendError(c, fiber.StatusBadRequest, err)
+		}
+
+		// Find the item and check perms
+		ctx, cancel := api.context(c)
+		defer cancel()
+		item, done, err := api.findItem(c, ctx, ActionGetOne)
+		if done {
+			return err
+		}
+
+		// The version of the item is a strong validator
+		if api.Version != nil {
+			etag := entityTag(api.Version(item), false)
+			c.Set(fiber.HeaderETag, etag)
+			if weakMatch(c.Get(fiber.HeaderIfNoneMatch), etag) {
+				return c.SendStatus(fiber.StatusNotModified)
+			}
+		}
+
+		// Return DTO JSON
+		api.setTimestamps(c, item)
+		dto, err := api.dtoOne(c, item)
+		if err != nil {
+			return api.sendDataError(c, err)
+		}
+		return api.sendItemWith(c, ActionGetOne, item, dto, api.embedded(item, embeds))
+	}
+}
+
+func createOne[T any, D any](api Api[T, D]) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+
+		// We don't need to check if creation is enabled because the POST function won't be registered
+
+		var amended D
+		if done, err := api.parseBody(c, &amended); done {
+			return err
+		}
+
+		if allowed, err := api.authorize(c, ActionCreate); !allowed {
+			return api.sendDenied(c, fiber.StatusUnauthorized, err)
+		}
+
+		// Embeds are checked before anything is created
+		embeds, err := api.embeds(c)
+		if err != nil {
+			return api.sendError(c, fiber.StatusBadRequest, err)
+		}
+
+		// A retry is answered as the create it repeats was, before the Exists check its own creation would fail
+		if done, err := api.replayCreate(c); done {
+			return err
+		}
+
+		// Create only if new
+		if api.Exists != nil && c.Get(fiber.HeaderIfNoneMatch) == "*" && api.Exists(amended) {
+			return api.sendError(c, fiber.StatusPreconditionFailed, nil)
+		}
+
+		// Create, with the client supplied id if there is one
+		ctx, cancel := api.context(c)
+		defer cancel()
+		var item T
+		if id := bodyID(c.Body()); id != "" && api.CreateWithID != nil {
+			_, exists, findErr := api.find(ctx, id)
+			if findErr != nil {
+				return api.sendError(c, fiber.StatusBadRequest, findErr)
+			}
+			if exists {
+				return api.sendError(c, fiber.StatusConflict, fmt.Errorf("%s already exists", id))
+			}
+			item, err = api.CreateWithID(id, amended)
+			if err == nil {
+				c.Status(fiber.StatusCreated).Location(strings.TrimSuffix(c.Path(), "/") + "/" + url.PathEscape(id))
+			}
+		} else if api.GenerateID != nil && api.CreateWithGeneratedID != nil {
+			id := api.GenerateID()
+			item, err = api.CreateWithGeneratedID(id, amended)
+			if err == nil {
+				c.Status(fiber.StatusCreated).Location(strings.TrimSuffix(c.Path(), "/") + "/" + url.PathEscape(id))
+			}
+		} else if api.Create != nil || api.CreateCtx != nil {
+			item, err = api.create(ctx, amended)
+		} else {
+			return api.sendError(c, fiber.StatusBadRequest, errors.New("an id is required"))
+		}
+		if timedOut(ctx) {
+			return api.sendError(c, fiber.StatusGatewayTimeout, nil)
+		}
+		if err != nil {
+			api.logf(slog.LevelError, "Error creating item: %v, %v\n", redact(item, api.SensitiveFields), err)
+			return api.sendDataError(c, err)
+		}
+		api.setTimestamps(c, item)
+		defer api.recordCreate(c)
+		if preferMinimal(c) {
+			return sendMinimal(c)
+		}
+		dto, err := api.dto(item)
+		if err != nil {
+			return api.sendDataError(c, err)
+		}
+		return api.sendItemWith(c, ActionCreate, item, dto, api.embedded(item, embeds))
+	}
+}
+
+// ifMatchFails reports if RequireIfMatch is set and the If-Match header does not match the Version of item
+func (api Api[T, D]) ifMatchFails(c *fiber.Ctx, item T) bool {
+	if !api.RequireIfMatch || api.Version == nil {
+		return false
+	}
+	return !strongMatch(c.Get(fiber.HeaderIfMatch), entityTag(api.Version(item), false))
+}
+
+// preferMinimal reports if the request asks for no response body with Prefer: return=minimal (RFC 7240).
+// A return preference, minimal or representation, is echoed in the Preference-Applied header.
+func preferMinimal(c *fiber.Ctx) bool {
+	for _, preference := range strings.Split(c.Get(HeaderPrefer), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(preference), "=")
+		if !strings.EqualFold(name, "return") {
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		switch value {
+		case "minimal", "representation":
+			c.Set(HeaderPreferenceApplied, "return="+value)
+			return value == "minimal"
+		}
+	}
+	return false
 }
 
-type Action uint8
+// sendMinimal answers a write without a body, 201 (Created) if it created the item and 204 (No Content) otherwise
+func sendMinimal(c *fiber.Ctx) error {
+	if c.Response().StatusCode() == fiber.StatusCreated {
+		return c.SendStatus(fiber.StatusCreated)
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
 
+// Headers of RFC 7240 preferences
 const (
-	ActionGetAll Action = iota
-	ActionGetOne
-	ActionMutate
-	ActionCreate
-	ActionDelete
+	HeaderPrefer            = "Prefer"
+	HeaderPreferenceApplied = "Preference-Applied"
 )
 
-func RegisterAPI[T any, D any](api fiber.Router, genericApi Api[T, D]) {
-	log.Printf("Registering REST api %s\n", genericApi.Path)
+// bodyID is the id field of a json object body, a string or a number, or "" if it has none
+func bodyID(body []byte) string {
+	var fields struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if json.Unmarshal(body, &fields) != nil || len(fields.ID) == 0 {
+		return ""
+	}
+	var id string
+	if json.Unmarshal(fields.ID, &id) == nil {
+		return id
+	}
+	var number json.Number
+	if json.Unmarshal(fields.ID, &number) == nil {
+		return number.String()
+	}
+	return ""
+}
 
-	// The api path
-	generic := api.Group("/" + genericApi.Path)
+// createMultipart creates an item from a multipart/form-data body with CreateMultipart and returns its Dto
+// Bodies that are not multipart are created from their JSON by Create, if set, and are 415 otherwise
+// 413 if the upload exceeds MaxBodyBytes
+func createMultipart[T any, D any](api Api[T, D]) fiber.Handler {
+	var createJSON fiber.Handler
+	if api.Create != nil || api.CreateCtx != nil || api.CreateWithID != nil || api.CreateWithGeneratedID != nil {
+		createJSON = createOne[T, D](api)
+	}
+	return func(c *fiber.Ctx) error {
 
-	// The two variants of GetAll
-	generic.Get("/", getAll[T, D](genericApi))
-	generic.Get("/page/:id", getAllPage[T, D](genericApi))
-	// The POST create  (if provided)
-	if genericApi.Mutate != nil {
-		generic.Post("/", createOne[T, D](genericApi))
+		mediaType, _, _ := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+		if mediaType != fiber.MIMEMultipartForm {
+			if createJSON != nil {
+				return createJSON(c)
+			}
+			return api.sendError(c, fiber.StatusUnsupportedMediaType, nil)
+		}
+		if api.bodyTooLarge(c) {
+			return api.sendError(c, fiber.StatusRequestEntityTooLarge, nil)
+		}
 
-	}
+		// Parse the form, binding the fields into the Dto
+		form, err := c.MultipartForm()
+		if err != nil {
+			api.logf(slog.LevelError, "Error parsing multipart body %v\n", err)
+			return api.sendError(c, fiber.StatusBadRequest, err)
+		}
+		var fields D
+		if err := c.BodyParser(&fields); err != nil {
+			api.logf(slog.LevelError, "Error parsing body %v\n", err)
+			return api.sendError(c, fiber.StatusBadRequest, err)
+		}
 
-	// The POST search  (if provided)
-	if genericApi.Search != nil {
-		generic.Post("/filter", search[T, D](genericApi))
+		if allowed, err := api.authorize(c, ActionCreate); !allowed {
+			return api.sendDenied(c, fiber.StatusUnauthorized, err)
+		}
 
+		// Create
+		item, err := api.CreateMultipart(c, form.File, fields)
+		if err != nil {
+			api.logf(slog.LevelError, "Error creating item: %v, %v\n", redact(item, api.SensitiveFields), err)
+			return api.sendDataError(c, err)
+		}
+		api.setTimestamps(c, item)
+		if preferMinimal(c) {
+			return sendMinimal(c)
+		}
+		dto, err := api.dto(item)
+		if err != nil {
+			return api.sendDataError(c, err)
+		}
+		return api.sendItem(c, ActionCreate, item, dto)
 	}
+}
 
-	// The SubEntity getters
-	// This is before the item Getter to ensure any name collision resolves to the SubEntity
-	for _, subEntity := range genericApi.SubEntities {
-		generic.Get("/:id/"+subEntity.SubPath, getSubEntity[T, D](genericApi, subEntity.Get))
-	}
+// mutateOne returns a single Jdo for a single item on the path after mutation from the supplied Jdo JSON in the body
+// 404 if entity is not in the cache
+// 400 if the body cannot be parsed or the mime type is not json
+// 428 without an If-Match when RequireIfMatch is set, 412 if it does not match the Version
+func mutateOne[T any, D any](api Api[T, D]) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if api.RequireIfMatch && c.Get(fiber.HeaderIfMatch) == "" {
+			return api.sendError(c, fiber.StatusPreconditionRequired, nil)
+		}
 
-	// The Single item Getter
-	generic.Get("/:id", getOne[T, D](genericApi))
+		// Parse the body, an empty one is the zero D if allowed
+		var amended D
+		if emptyBody(c) {
+			if !api.AllowEmptyBody {
+				return api.sendError(c, fiber.StatusBadRequest, errors.New("request body required"))
+			}
+		} else if done, err := api.parseBody(c, &amended); done {
+			return err
+		}
 
-	// The PUT mutation (if provided)
-	if genericApi.Mutate != nil {
-		generic.Put("/:id", mutateOne[T, D](genericApi))
+		// Find the item
+		id := c.Params("id")
+		ctx, cancel := api.context(c)
+		defer cancel()
+		var before map[string]any
+		item, ok, err := api.find(ctx, id)
+		if err != nil {
+			return api.sendError(c, fiber.StatusBadRequest, err)
+		}
+		if timedOut(ctx) {
+			return api.sendError(c, fiber.StatusGatewayTimeout, nil)
+		}
+		if !ok && api.PutCreatesWithPathID && api.CreateWithID != nil {
+			// Create using the path id
+			if allowed, err := api.authorize(c, ActionCreate); !allowed {
+				return api.sendDenied(c, fiber.StatusUnauthorized, err)
+			}
+			item, err = api.CreateWithID(id, amended)
+			if err != nil {
+				api.logf(slog.LevelError, "Error creating item: %v, %v\n", redact(item, api.SensitiveFields), err)
+				return api.sendDataError(c, err)
+			}
+			c.Status(fiber.StatusCreated).Location(c.Path())
+		} else {
+			// Not found and perms check
+			if status, err := api.checkItem(c, ActionMutate, item, ok); status != 0 {
+				return api.sendCheckFailed(c, ActionMutate, status, err)
+			}
+			if api.ifMatchFails(c, item) {
+				return api.sendError(c, fiber.StatusPreconditionFailed, nil)
+			}
+			// Capture the item before the mutation to check and report the changes
+			if api.ReturnDiff || len(api.ImmutableFields) > 0 {
+				dto, err := api.dto(item)
+				if err != nil {
+					return api.sendDataError(c, err)
+				}
+				stored, _ := api.jsonObject(dto)
+				if err := api.checkImmutable(c.Body(), stored, amended); err != nil {
+					return api.sendDataError(c, err)
+				}
+				if api.ReturnDiff {
+					before = stored
+				}
+			}
+			item, err = api.mutate(ctx, item, amended)
+			if timedOut(ctx) {
+				return api.sendError(c, fiber.StatusGatewayTimeout, nil)
+			}
+			if err != nil {
+				api.logf(slog.LevelError, "Error mutating item: %v, %v\n", redact(item, api.SensitiveFields), err)
+				return api.sendDataError(c, err)
+			}
+		}
 
+		api.setTimestamps(c, item)
+		if preferMinimal(c) {
+			return sendMinimal(c)
+		}
+		dto, err := api.dto(item)
+		if err != nil {
+			return api.sendDataError(c, err)
+		}
+		if before != nil {
+			after, _ := api.jsonObject(dto)
+			return api.sendItemWith(c, ActionMutate, item, dto, map[string]any{"_changes": diffFields(before, after)})
+		}
+		return api.sendItem(c, ActionMutate, item, dto)
 	}
+}
 
-	// The GET mutation (if provided)
-	if genericApi.Delete != nil {
-		generic.Delete("/:id", deleteOne[T, D](genericApi))
+// checkImmutable returns a ValidationError listing the ImmutableFields the submitted Dto changes from the stored one.
+// Only the fields present in the body are checked, omitting one is not a change.
+func (api Api[T, D]) checkImmutable(body []byte, stored map[string]any, submitted D) error {
+	if len(api.ImmutableFields) == 0 {
+		return nil
+	}
+	var present map[string]json.RawMessage
+	if json.Unmarshal(body, &present) != nil {
+		return nil
+	}
+	amended, _ := api.jsonObject(submitted)
+	changed := map[string]string{}
+	for _, field := range api.ImmutableFields {
+		if _, ok := present[field]; ok && !reflect.DeepEqual(stored[field], amended[field]) {
+			changed[field] = "is immutable"
+		}
+	}
+	if len(changed) > 0 {
+		return &ValidationError{Fields: changed}
+	}
+	return nil
+}
 
+// checkPatchImmutable returns a ValidationError listing the ImmutableFields the patch changes in the json of the item.
+// Only the fields the patch touches are checked
+func (api Api[T, D]) checkPatchImmutable(doc []byte, patched []byte, touched []string) error {
+	if len(api.ImmutableFields) == 0 {
+		return nil
+	}
+	var before, after map[string]any
+	if json.Unmarshal(doc, &before) != nil || json.Unmarshal(patched, &after) != nil {
+		return nil
 	}
+	changed := map[string]string{}
+	for _, field := range api.ImmutableFields {
+		if slices.Contains(touched, field) && !reflect.DeepEqual(before[field], after[field]) {
+			changed[field] = "is immutable"
+		}
+	}
+	if len(changed) > 0 {
+		return &ValidationError{Fields: changed}
+	}
+	return nil
 }
 
-// getAll returns all entities as their Jdo type
-func getAll[T any, D any](api Api[T, D]) fiber.Handler {
+// deleteAll deletes the whole collection, responding {"deleted": n}.
+// The request must confirm the intent with ?confirm=true, otherwise 400
+func deleteAll[T any, D any](api Api[T, D]) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Perms check
-		if api.Validator != nil && !api.Validator(c, ActionGetAll) {
-			return c.SendStatus(fiber.StatusUnauthorized)
+		if allowed, err := api.authorize(c, ActionDeleteAll); !allowed {
+			return api.sendDenied(c, fiber.StatusUnauthorized, err)
+		}
+		if c.Query("confirm") != "true" {
+			return api.sendError(c, fiber.StatusBadRequest, errors.New("deleting all items requires ?confirm=true"))
 		}
 
-		// Find all
-		// Transform to DTO
-		// Send as JSON
-		var all []D
-
-		for _, v := range api.FindAll() {
-			all = append(all, api.Dto(v))
-
+		n, err := api.DeleteAll(c)
+		if err != nil {
+			api.logf(slog.LevelError, "Error deleting all items: %v\n", err)
+			return api.sendDataError(c, err)
 		}
-		return c.JSON(all)
+		return api.send(c, ActionDeleteAll, fiber.Map{"deleted": n})
 	}
 }
-func getAllPage[T any, D any](api Api[T, D]) fiber.Handler {
+
+// patchOne applies the JSON Patch (RFC 6902) in the body to the item on the path and returns the resulting Dto
+// 404 if entity is not in the cache
+// 415 if the body is not application/json-patch+json
+// 422 if the patch is invalid or does not apply to the item, 409 if a test operation fails
+// 428 without an If-Match when RequireIfMatch is set, 412 if it does not match the Version
+func patchOne[T any, D any](api Api[T, D]) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		// Perms check
-		id := c.Params("id")
-		i, err := strconv.ParseInt(id, 10, 64)
-		if err != nil {
-			// 可能字符串 s 不是合法的整数格式，处理错误
+		if api.RequireIfMatch && c.Get(fiber.HeaderIfMatch) == "" {
+			return api.sendError(c, fiber.StatusPreconditionRequired, nil)
 		}
 
-		if api.Validator != nil && !api.Validator(c, ActionGetAll) {
-			return c.SendStatus(fiber.StatusUnauthorized)
+		mediaType, _, _ := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+		if mediaType != MIMEApplicationJSONPatch {
+			return api.sendError(c, fiber.StatusUnsupportedMediaType, nil)
+		}
+		if api.bodyTooLarge(c) {
+			return api.sendError(c, fiber.StatusRequestEntityTooLarge, nil)
+		}
+		if emptyBody(c) && !api.AllowEmptyBody {
+			return api.sendError(c, fiber.StatusBadRequest, errors.New("request body required"))
+		}
+
+		ctx, cancel := api.context(c)
+		defer cancel()
+		item, done, err := api.findItem(c, ctx, ActionMutate)
+		if done {
+			return err
+		}
+		if api.ifMatchFails(c, item) {
+			return api.sendError(c, fiber.StatusPreconditionFailed, nil)
+		}
+
+		// Only the fields the Dto exposes can be patched or tested
+		stored, err := api.dto(item)
+		if err != nil {
+			return api.sendDataError(c, err)
+		}
+		visible, _ := api.jsonObject(stored)
+		fields, err := patchFields(c.Body())
+		if err != nil {
+			return api.sendError(c, fiber.StatusUnprocessableEntity, err)
+		}
+		for _, field := range fields {
+			if _, ok := visible[field]; !ok {
+				return api.sendError(c, fiber.StatusUnprocessableEntity, fmt.Errorf("field %q cannot be patched", field))
+			}
 		}
-		// Find all
-		// Transform to DTO
-		// Send as JSON
-		var all Page[T]
 
-		all = api.FindAllPage(i)
-		return c.JSON(all)
+		// Patch the json of the item
+		doc, err := json.Marshal(item)
+		if err != nil {
+			return api.sendDataError(c, err)
+		}
+		patched, err := applyJSONPatch(doc, c.Body())
+		if errors.Is(err, errPatchTestFailed) {
+			return api.sendError(c, fiber.StatusConflict, err)
+		}
+		if err != nil {
+			return api.sendError(c, fiber.StatusUnprocessableEntity, err)
+		}
+		if err := api.checkPatchImmutable(doc, patched, fields); err != nil {
+			return api.sendDataError(c, err)
+		}
+		var edited T
+		if err := json.Unmarshal(patched, &edited); err != nil {
+			return api.sendError(c, fiber.StatusUnprocessableEntity, err)
+		}
 
+		item, err = api.ApplyPatch(edited)
+		if err != nil {
+			api.logf(slog.LevelError, "Error patching item: %v, %v\n", redact(item, api.SensitiveFields), err)
+			return api.sendDataError(c, err)
+		}
+		api.setTimestamps(c, item)
+		dto, err := api.dto(item)
+		if err != nil {
+			return api.sendDataError(c, err)
+		}
+		return api.sendItem(c, ActionMutate, item, dto)
 	}
 }
 
-// getAll returns all entities as their Jdo type
-func search[T any, D any](api Api[T, D]) fiber.Handler {
+// deleteOne deletes the item on the path, responding {"status": "deleted", "id": id} as JSON
+// 404 if entity is not in the cache
+// 428 without an If-Match when RequireIfMatch is set, 412 if it does not match the Version
+func deleteOne[T any, D any](api Api[T, D]) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		// Perms check
-		if api.Validator != nil && !api.Validator(c, ActionGetAll) {
-			return c.SendStatus(fiber.StatusUnauthorized)
+		if api.RequireIfMatch && c.Get(fiber.HeaderIfMatch) == "" {
+			return api.sendError(c, fiber.StatusPreconditionRequired, nil)
 		}
 
-		var filter D
-		if err := c.BodyParser(&filter); err != nil {
-			log.Printf("Error parsing body %v\n", err)
-			return c.SendStatus(fiber.StatusBadRequest)
+		ctx, cancel := api.context(c)
+		defer cancel()
+		item, done, err := api.findItem(c, ctx, ActionDelete)
+		if done {
+			return err
+		}
+		if api.ifMatchFails(c, item) {
+			return api.sendError(c, fiber.StatusPreconditionFailed, nil)
 		}
 
-		// Search with filter
-		// Transform to DTO
-		// Send as JSON
-		var all []D
-		for _, v := range api.Search(filter) {
-			all = append(all, api.Dto(v))
+		// Report the affected count if available
+		if api.DeleteN != nil {
+			n, err := api.DeleteN(item)
+			if err != nil {
+				api.logf(slog.LevelError, "Error deleting item: %v\n", err)
+				return api.sendDataError(c, err)
+			}
+			return api.send(c, ActionDelete, fiber.Map{"deleted": n})
+		}
+
+		item, err = api.delete(ctx, item)
+		if timedOut(ctx) {
+			return api.sendError(c, fiber.StatusGatewayTimeout, nil)
+		}
+		if err != nil {
+			api.logf(slog.LevelError, "Error deleting item: %v\n", err)
+			return api.sendDataError(c, err)
 		}
-		return c.JSON(all)
+
+		return api.send(c, ActionDelete, fiber.Map{"status": "deleted", "id": c.Params("id")})
 	}
 }
 
-// getOne returns a single Jdo for a single item on the path.
+// customAction runs a CustomAction on the item on the path and returns the resulting Dto
 // 404 if entity is not in the cache
-func getOne[T any, D any](api Api[T, D]) fiber.Handler {
+func customAction[T any, D any](api Api[T, D], action CustomAction[T, D]) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 
-		// Find the item
-		id := c.Params("id")
-		item, ok := api.Find(id)
-		if !ok {
-			// don't leak existence information if unauthorized
-			if api.Validator != nil && !api.Validator(c, ActionGetOne) {
-				return c.SendStatus(fiber.StatusUnauthorized)
-			}
-			return c.SendStatus(fiber.StatusNotFound)
+		ctx, cancel := api.context(c)
+		defer cancel()
+		item, done, err := api.findItem(c, ctx, action.Action)
+		if done {
+			return err
+		}
+
+		item, err = action.Handler(c, item)
+		if err != nil {
+			api.logf(slog.LevelError, "Error in custom action %s: %v\n", action.SubPath, err)
+			return api.sendDataError(c, err)
+		}
+		dto, err := api.dto(item)
+		if err != nil {
+			return api.sendDataError(c, err)
 		}
+		return api.sendItem(c, action.Action, item, dto)
+	}
+}
+
+// exportFlushEvery is how many items are written between flushes of an export stream
+const exportFlushEvery = 100
 
+// exportAll streams all entities as a single JSON array of their Dto type.
+// Items are encoded one at a time so the serialized collection is never held in memory.
+func exportAll[T any, D any](api Api[T, D]) fiber.Handler {
+	return func(c *fiber.Ctx) error {
 		// Perms check
-		if api.Validator != nil && !api.Validator(c, ActionGetOne, item) {
-			return c.SendStatus(fiber.StatusUnauthorized)
+		if allowed, err := api.authorize(c, ActionGetAll); !allowed {
+			return api.sendDenied(c, fiber.StatusUnauthorized, err)
 		}
 
-		// Return DTO JSON
-		return c.JSON(api.Dto(item))
+		ctx, cancel := api.context(c)
+		defer cancel()
+		found := api.findAll(ctx)
+		if timedOut(ctx) {
+			return api.sendError(c, fiber.StatusGatewayTimeout, nil)
+		}
+
+		// The stream outlives c, so the export is localized up front
+		dto := api.dto
+		if api.DtoLocalized != nil {
+			locale := api.locale(c)
+			dto = func(item T) (D, error) {
+				return api.DtoLocalized(item, locale), nil
+			}
+		}
+
+		c.Set(fiber.HeaderContentType, api.contentType())
+		conn := c.Context().Conn()
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer api.recoverStream("export", conn)
+			defer api.streamIdle(conn, 0)
+			w.WriteString("[")
+			for i, v := range found {
+				// A failed item leaves the array unclosed, so the export is visibly broken rather than short
+				dto, err := dto(v)
+				if err != nil {
+					api.logf(slog.LevelError, "Error transforming export item: %v\n", err)
+					w.Flush()
+					return
+				}
+				b, err := api.marshal(dto)
+				if err != nil {
+					api.logf(slog.LevelError, "Error encoding export item: %v\n", err)
+					w.Flush()
+					return
+				}
+				api.streamIdle(conn, api.StreamIdleTimeout)
+				if i > 0 {
+					w.WriteString(",")
+				}
+				if _, err := w.Write(b); err != nil {
+					api.logf(slog.LevelError, "Export to %s aborted: %v\n", conn.RemoteAddr(), err)
+					return
+				}
+				if (i+1)%exportFlushEvery == 0 {
+					if err := w.Flush(); err != nil {
+						// The client has gone away, or stalled beyond the StreamIdleTimeout
+						api.logf(slog.LevelError, "Export to %s aborted: %v\n", conn.RemoteAddr(), err)
+						return
+					}
+				}
+			}
+			w.WriteString("]")
+			w.Flush()
+		})
+		return nil
 	}
 }
 
-func createOne[T any, D any](api Api[T, D]) fiber.Handler {
-	return func(c *fiber.Ctx) error {
+// MIMEApplicationNDJSON is the content type of newline delimited json, one json value per line
+const MIMEApplicationNDJSON = "application/x-ndjson"
 
-		// We don't need to check if creation is enabled because the POST function won't be registered
+// wantsNDJSON reports if the request prefers a newline delimited json stream and StreamNDJSON allows it
+func (api Api[T, D]) wantsNDJSON(c *fiber.Ctx) bool {
+	return api.StreamNDJSON && c.Accepts(fiber.MIMEApplicationJSON, MIMEApplicationNDJSON) == MIMEApplicationNDJSON
+}
 
-		var amended D
-		if err := c.BodyParser(&amended); err != nil {
-			log.Printf("Error parsing body %v\n", err)
-			return c.SendStatus(fiber.StatusBadRequest)
+// streamNDJSON streams the n values element returns as newline delimited json, flushing as it goes.
+// element is called while streaming, after the handler returns, so it must not use the fiber.Ctx.
+func (api Api[T, D]) streamNDJSON(c *fiber.Ctx, n int, element func(i int) (any, error)) error {
+	c.Set(fiber.HeaderContentType, MIMEApplicationNDJSON)
+	conn := c.Context().Conn()
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer api.recoverStream("NDJSON stream", conn)
+		defer api.streamIdle(conn, 0)
+		for i := 0; i < n; i++ {
+			v, err := element(i)
+			if err == nil {
+				var b []byte
+				if b, err = api.marshal(v); err == nil {
+					api.streamIdle(conn, api.StreamIdleTimeout)
+					w.Write(b)
+					_, err = w.WriteString("\n")
+				}
+			}
+			if err == nil && (i+1)%exportFlushEvery == 0 {
+				err = w.Flush()
+			}
+			if err != nil {
+				// The client has gone away, stalled beyond the StreamIdleTimeout, or the element failed
+				api.logf(slog.LevelError, "NDJSON stream to %s aborted: %v\n", conn.RemoteAddr(), err)
+				return
+			}
 		}
+		w.Flush()
+	})
+	return nil
+}
+
+// recoverStream, deferred by a stream writer, recovers a panic while streaming, e.g. in a user supplied Dto, if
+// RecoverPanics is set.  The status is already sent, so the panic is logged and the stream cut short.
+func (api Api[T, D]) recoverStream(stream string, conn net.Conn) {
+	if !api.RecoverPanics {
+		return
+	}
+	if r := recover(); r != nil {
+		api.logf(slog.LevelError, "Panic in %s to %s: %v\n%s", stream, conn.RemoteAddr(), r, debug.Stack())
+	}
+}
+
+// streamIdle bounds how long the next writes of a stream to conn may block, 0 removing the bound.
+// A write the client does not consume in time fails, aborting the stream.
+func (api Api[T, D]) streamIdle(conn net.Conn, timeout time.Duration) {
+	if api.StreamIdleTimeout <= 0 {
+		return
+	}
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	conn.SetWriteDeadline(deadline)
+}
 
-		if api.Validator != nil && !api.Validator(c, ActionCreate) {
-			return c.SendStatus(fiber.StatusUnauthorized)
+// getTrash returns the soft deleted entities as their Jdo type
+func getTrash[T any, D any](api Api[T, D]) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Perms check
+		if allowed, err := api.authorize(c, ActionViewTrash); !allowed {
+			return api.sendDenied(c, fiber.StatusUnauthorized, err)
 		}
 
-		// Create
-		item, err := api.Create(amended)
+		all, err := api.dtoAll(c, api.FindDeleted())
 		if err != nil {
-			log.Printf("Error creating item: %v, %v\n", item, err)
-			return c.SendStatus(fiber.StatusInternalServerError)
+			return api.sendDataError(c, err)
 		}
-		return c.JSON(api.Dto(item))
+		return api.send(c, ActionViewTrash, all)
 	}
 }
 
-// mutateOne returns a single Jdo for a single item on the path after mutation from the supplied Jdo JSON in the body
-// 404 if entity is not in the cache
-// 400 if the body cannot be parsed or the mime type is not json
-func mutateOne[T any, D any](api Api[T, D]) fiber.Handler {
-	return func(c *fiber.Ctx) error {
+// eventsKeepAlive is the interval of comment frames on an idle event stream, they detect disconnected clients
+const eventsKeepAlive = 15 * time.Second
 
-		// Parse the body
-		var amended D
-		if err := c.BodyParser(&amended); err != nil {
-			log.Printf("Error parsing body %v\n", err)
-			return c.SendStatus(fiber.StatusBadRequest)
+// streamEvents streams the ChangeEvents from Subscribe as Server-Sent Events until the client disconnects
+func streamEvents[T any, D any](api Api[T, D]) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Perms check
+		if allowed, err := api.authorize(c, ActionGetAll); !allowed {
+			return api.sendDenied(c, fiber.StatusUnauthorized, err)
 		}
 
-		// Find the item
-		id := c.Params("id")
-		item, ok := api.Find(id)
-		var err error
-		if !ok {
-			// Perms check for creation
-			if api.Validator != nil && !api.Validator(c, ActionMutate) {
-				return c.SendStatus(fiber.StatusUnauthorized)
+		c.Set(fiber.HeaderContentType, "text/event-stream")
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+		c.Set(fiber.HeaderConnection, "keep-alive")
+
+		// The stream outlives the handler, so it gets its own context cancelled when the stream ends
+		ctx, cancel := context.WithCancel(context.Background())
+		events := api.Subscribe(ctx)
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer cancel()
+			keepAlive := time.NewTicker(eventsKeepAlive)
+			defer keepAlive.Stop()
+			for {
+				select {
+				case event, ok := <-events:
+					if !ok {
+						return
+					}
+					data, err := api.marshal(event.Data)
+					if err != nil {
+						api.logf(slog.LevelError, "Error encoding event: %v\n", err)
+						continue
+					}
+					fmt.Fprintf(w, "event: %s\nid: %s\ndata: %s\n\n", event.Action, event.ID, data)
+				case <-keepAlive.C:
+					w.WriteString(": keep-alive\n\n")
+				}
+				if err := w.Flush(); err != nil {
+					// The client has gone away
+					return
+				}
 			}
-			// If not found
-			return c.SendStatus(fiber.StatusNotFound)
-		} else {
-			// Perms check
-			if api.Validator != nil && !api.Validator(c, ActionMutate, item) {
-				return c.SendStatus(fiber.StatusUnauthorized)
+		})
+		return nil
+	}
+}
+
+// getSchema returns the JSON Schema of D, generated once at registration
+func getSchema[T any, D any](api Api[T, D]) fiber.Handler {
+	var emptyD D
+	schema := jsonSchema(reflect.TypeOf(emptyD))
+	return func(c *fiber.Ctx) error {
+		return api.send(c, ActionGetAll, schema)
+	}
+}
+
+// getMeta returns the fields a client may sort and filter by and the actions the Api supports,
+// derived once from its configuration
+func getMeta[T any, D any](api Api[T, D]) fiber.Handler {
+	sortable := []string{}
+	if api.SearchQuery != nil {
+		sortable = append(sortable, api.SortableFields...)
+	}
+	filterable := []string{}
+	if api.SearchQuery != nil || api.Search != nil || api.SearchCtx != nil || api.SearchBool != nil {
+		var emptyD D
+		if properties, ok := jsonSchema(reflect.TypeOf(emptyD))["properties"].(map[string]any); ok {
+			for name := range properties {
+				if len(api.AllowedFilterFields) == 0 || slices.Contains(api.AllowedFilterFields, name) {
+					filterable = append(filterable, name)
+				}
 			}
-			item, err = api.Mutate(item, amended)
-			if err != nil {
-				log.Printf("Error mutating item: %v, %v\n", item, err)
-				return c.SendStatus(fiber.StatusInternalServerError)
+		}
+		slices.Sort(filterable)
+	}
+	// The actions are listed on first use as the routes include this one
+	actions := []string{}
+	var listActions sync.Once
+	return func(c *fiber.Ctx) error {
+		// Perms check
+		if allowed, err := api.authorize(c, ActionGetAll); !allowed {
+			return api.sendDenied(c, fiber.StatusUnauthorized, err)
+		}
+		listActions.Do(func() {
+			for _, route := range api.routes() {
+				if name := route.action.String(); !slices.Contains(actions, name) {
+					actions = append(actions, name)
+				}
 			}
+		})
+		return api.send(c, ActionGetAll, fiber.Map{"sortable": sortable, "filterable": filterable, "actions": actions})
+	}
+}
+
+// deleteSubEntityOne deletes the child :subId of the request item :id with the Delete function, responding 204
+// 404 if either the entity or the child is not found
+func deleteSubEntityOne[T any, D any](api Api[T, D], subEntity SubEntity[T, D]) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+
+		ctx, cancel := api.context(c)
+		defer cancel()
+		item, done, err := api.findItem(c, ctx, ActionDelete)
+		if done {
+			return err
 		}
 
-		return c.JSON(api.Dto(item))
+		subID := c.Params("subId")
+		if subEntity.FindOne != nil {
+			if _, ok := subEntity.FindOne(item, subID); !ok {
+				return api.sendError(c, fiber.StatusNotFound, nil)
+			}
+		}
+		if err := subEntity.Delete(item, subID); err != nil {
+			api.logf(slog.LevelError, "Error deleting %s %s: %v\n", subEntity.SubPath, subID, err)
+			return api.sendDataError(c, err)
+		}
+		return c.SendStatus(fiber.StatusNoContent)
 	}
 }
 
-// deleteOne returns a single Jdo for a single item on the path after mutation/deletion
-// 404 if entity is not in the cache
-func deleteOne[T any, D any](api Api[T, D]) fiber.Handler {
+// getEnums returns the EnumValues, all of them or those of the :field.
+// 404 if the field is not listed
+func getEnums[T any, D any](api Api[T, D]) fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		// Perms check
+		if allowed, err := api.authorize(c, ActionGetAll); !allowed {
+			return api.sendDenied(c, fiber.StatusUnauthorized, err)
+		}
 
-		id := c.Params("id")
-		item, ok := api.Find(id)
-		if !ok {
-			// don't leak existence information if unauthorized
-			if api.Validator != nil && !api.Validator(c, ActionDelete) {
-				return c.SendStatus(fiber.StatusUnauthorized)
+		field := c.Params("field")
+		if field == "" {
+			enums := api.EnumValues
+			if enums == nil {
+				enums = map[string][]string{}
 			}
-			return c.SendStatus(fiber.StatusNotFound)
+			return api.send(c, ActionGetAll, enums)
+		}
+		values, ok := api.EnumValues[field]
+		if !ok {
+			return api.sendError(c, fiber.StatusNotFound, nil)
 		}
+		return api.send(c, ActionGetAll, values)
+	}
+}
 
-		if api.Validator != nil && !api.Validator(c, ActionDelete, item) {
-			return c.SendStatus(fiber.StatusUnauthorized)
+// groupBy fulfils a request for the GroupByResource group, a map of each key to the reduction of its items
+func groupBy[T any, D any](api Api[T, D], group GroupByResource[T]) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Perms check
+		if allowed, err := api.authorize(c, ActionGetAll); !allowed {
+			return api.sendDenied(c, fiber.StatusUnauthorized, err)
 		}
 
-		var err error
-		item, err = api.Delete(item)
-		if err != nil {
-			log.Printf("Error deleting item: %v\n", err)
-			return c.SendStatus(fiber.StatusInternalServerError)
+		ctx, cancel := api.context(c)
+		defer cancel()
+		found := api.findAll(ctx)
+		if timedOut(ctx) {
+			return api.sendError(c, fiber.StatusGatewayTimeout, nil)
 		}
 
-		return c.SendString("deleted")
+		buckets := make(map[string][]T)
+		for _, item := range found {
+			key := group.KeyFn(item)
+			buckets[key] = append(buckets[key], item)
+		}
+		groups := make(map[string]any, len(buckets))
+		for key, items := range buckets {
+			groups[key] = group.Reduce(items)
+		}
+		return api.send(c, ActionGetAll, groups)
 	}
 }
 
-// getSubEntity fulfils a request for a SubEntity of the request item :id, supplied by the getter function
+// getSubEntity fulfils a request for a SubEntity of the request item :id, supplied by its Get function,
+// with their number in the X-Total-Count header
 // 404 if entity is not in the cache
-func getSubEntity[T any, D any](api Api[T, D], getter func(entity T) []any) fiber.Handler {
+func getSubEntity[T any, D any](api Api[T, D], subEntity SubEntity[T, D]) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 
-		id := c.Params("id")
-		item, ok := api.Find(id)
-		if !ok {
-			// don't leak existence information if unauthorized
-			if api.Validator != nil && !api.Validator(c, ActionGetOne) {
-				return c.SendStatus(fiber.StatusUnauthorized)
+		ctx, cancel := api.context(c)
+		defer cancel()
+		item, done, err := api.findItem(c, ctx, ActionGetOne)
+		if done {
+			return err
+		}
+
+		subAll := subEntity.Get(item)
+		c.Set(HeaderTotalCount, strconv.Itoa(len(subAll)))
+		if api.wantsNDJSON(c) {
+			return api.streamNDJSON(c, len(subAll), func(i int) (any, error) {
+				return subEntity.dto(subAll[i]), nil
+			})
+		}
+		if subEntity.Dto != nil {
+			dtos := make([]any, len(subAll))
+			for i, child := range subAll {
+				dtos[i] = subEntity.dto(child)
 			}
-			return c.SendStatus(fiber.StatusNotFound)
+			subAll = dtos
 		}
+		return api.send(c, ActionGetOne, subAll)
+	}
+
+}
+
+// getAncestors returns the ancestors of the request item :id, from the root down to its parent.
+// Ancestors that are items are sent as their Dto, anything else as is.
+// 404 if entity is not in the cache
+func getAncestors[T any, D any](api Api[T, D]) fiber.Handler {
+	return func(c *fiber.Ctx) error {
 
-		if api.Validator != nil && !api.Validator(c, ActionGetOne, item) {
-			return c.SendStatus(fiber.StatusUnauthorized)
+		ctx, cancel := api.context(c)
+		defer cancel()
+		item, done, err := api.findItem(c, ctx, ActionGetOne)
+		if done {
+			return err
 		}
 
-		subAll := getter(item)
-		return c.JSON(subAll)
+		ancestors := api.Ancestors(item)
+		chain := make([]any, len(ancestors))
+		for i, ancestor := range ancestors {
+			chain[i] = ancestor
+			if parent, ok := ancestor.(T); ok {
+				if chain[i], err = api.dtoOne(c, parent); err != nil {
+					return api.sendDataError(c, err)
+				}
+			}
+		}
+		return api.send(c, ActionGetOne, chain)
 	}
+}
+
+// getSubEntityOne fulfils a request for a single child :subId of the request item :id, supplied by the FindOne function
+// 404 if either the entity or the child is not found
+func getSubEntityOne[T any, D any](api Api[T, D], subEntity SubEntity[T, D]) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+
+		ctx, cancel := api.context(c)
+		defer cancel()
+		item, done, err := api.findItem(c, ctx, ActionGetOne)
+		if done {
+			return err
+		}
 
+		child, ok := subEntity.FindOne(item, c.Params("subId"))
+		if !ok {
+			return api.sendError(c, fiber.StatusNotFound, nil)
+		}
+		return api.send(c, ActionGetOne, subEntity.dto(child))
+	}
 }
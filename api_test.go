@@ -0,0 +1,2529 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// widget is the item type of the tests
+type widget struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Owner  string `json:"owner"`
+}
+
+// widgetDto is the Dto of a widget, hiding its owner
+type widgetDto struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+func toWidgetDto(w widget) widgetDto {
+	return widgetDto{ID: w.ID, Name: w.Name, Status: w.Status}
+}
+
+// widgetStore is an in memory store of widgets, counting the Find calls
+type widgetStore struct {
+	mu    sync.Mutex
+	items map[string]widget
+	finds int
+}
+
+func newWidgetStore(items ...widget) *widgetStore {
+	s := &widgetStore{items: map[string]widget{}}
+	for _, w := range items {
+		s.items[w.ID] = w
+	}
+	return s
+}
+
+func (s *widgetStore) find(id string) (widget, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finds++
+	w, ok := s.items[id]
+	return w, ok
+}
+
+// findAll returns the widgets ordered by id
+func (s *widgetStore) findAll() []widget {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var all []widget
+	for _, w := range s.items {
+		all = append(all, w)
+	}
+	slices.SortFunc(all, func(a, b widget) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return all
+}
+
+// search returns the widgets with the status of the filter, all of them for an empty status
+func (s *widgetStore) search(filter widgetDto) []widget {
+	var found []widget
+	for _, w := range s.findAll() {
+		if filter.Status == "" || w.Status == filter.Status {
+			found = append(found, w)
+		}
+	}
+	return found
+}
+
+func (s *widgetStore) create(d widgetDto) (widget, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d.ID == "" {
+		return widget{}, errors.New("id required")
+	}
+	w := widget{ID: d.ID, Name: d.Name, Status: d.Status}
+	s.items[w.ID] = w
+	return w, nil
+}
+
+func (s *widgetStore) mutate(w widget, d widgetDto) (widget, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Name, w.Status = d.Name, d.Status
+	s.items[w.ID] = w
+	return w, nil
+}
+
+func (s *widgetStore) delete(w widget) (widget, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, w.ID)
+	return w, nil
+}
+
+// widgetApi returns an Api over the store at /widgets, without a Validator so everything is allowed
+func widgetApi(s *widgetStore) Api[widget, widgetDto] {
+	return Api[widget, widgetDto]{
+		Path:    "widgets",
+		Find:    s.find,
+		FindAll: s.findAll,
+		Create:  s.create,
+		Mutate:  s.mutate,
+		Delete:  s.delete,
+		Dto:     toWidgetDto,
+	}
+}
+
+// numberedWidgets returns n widgets with the ids w01, w02, ...
+func numberedWidgets(n int) []widget {
+	var all []widget
+	for i := 1; i <= n; i++ {
+		id := fmt.Sprintf("w%02d", i)
+		all = append(all, widget{ID: id, Name: "widget " + id, Status: "active"})
+	}
+	return all
+}
+
+// serve registers api on a fresh app
+func serve[T any, D any](api Api[T, D]) *fiber.App {
+	app := fiber.New()
+	RegisterAPI(app, api)
+	return app
+}
+
+// call issues a request to app, returning the response and its body.
+// header lists header names and values in turn, a body defaults to the application/json content type.
+func call(t *testing.T, app *fiber.App, method string, target string, body string, header ...string) (*http.Response, string) {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, target, err)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%s %s: reading the body: %v", method, target, err)
+	}
+	return resp, string(b)
+}
+
+// expectStatus fails the test if resp does not have the status
+func expectStatus(t *testing.T, resp *http.Response, body string, status int) {
+	t.Helper()
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s: status %d, want %d: %s", resp.Request.Method, resp.Request.URL, resp.StatusCode, status, body)
+	}
+}
+
+// decodeBody decodes the json body of a response into v
+func decodeBody(t *testing.T, body string, v any) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(body), v); err != nil {
+		t.Fatalf("decoding %s: %v", body, err)
+	}
+}
+
+func TestPageLinks(t *testing.T) {
+	api := widgetApi(newWidgetStore(numberedWidgets(25)...))
+	app := serve(api)
+
+	tests := []struct {
+		page   string
+		links  []string
+		absent []string
+	}{
+		{page: "1", links: []string{`/widgets/page/2>; rel="next"`, `/widgets/page/1>; rel="first"`, `/widgets/page/3>; rel="last"`}, absent: []string{`rel="prev"`}},
+		{page: "2", links: []string{`/widgets/page/3>; rel="next"`, `/widgets/page/1>; rel="prev"`, `/widgets/page/1>; rel="first"`, `/widgets/page/3>; rel="last"`}},
+		{page: "3", links: []string{`/widgets/page/2>; rel="prev"`, `/widgets/page/1>; rel="first"`, `/widgets/page/3>; rel="last"`}, absent: []string{`rel="next"`}},
+	}
+	for _, tt := range tests {
+		resp, body := call(t, app, fiber.MethodGet, "/widgets/page/"+tt.page, "")
+		expectStatus(t, resp, body, fiber.StatusOK)
+		link := resp.Header.Get(fiber.HeaderLink)
+		for _, want := range tt.links {
+			if !strings.Contains(link, want) {
+				t.Errorf("page %s: Link %q lacks %q", tt.page, link, want)
+			}
+		}
+		for _, unwanted := range tt.absent {
+			if strings.Contains(link, unwanted) {
+				t.Errorf("page %s: Link %q has %q", tt.page, link, unwanted)
+			}
+		}
+	}
+}
+
+func TestPageLinksSinglePage(t *testing.T) {
+	app := serve(widgetApi(newWidgetStore(numberedWidgets(3)...)))
+
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/page/1?size=5", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	link := resp.Header.Get(fiber.HeaderLink)
+	if strings.Contains(link, `rel="next"`) || strings.Contains(link, `rel="prev"`) {
+		t.Errorf("Link %q of the only page has next or prev", link)
+	}
+	if !strings.Contains(link, `/widgets/page/1?size=5>; rel="last"`) {
+		t.Errorf("Link %q lacks the last page with the query", link)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	store := newWidgetStore(widget{ID: "a", Name: "alpha"})
+	api := widgetApi(store)
+	api.Timeout = 20 * time.Millisecond
+	api.FindCtx = func(ctx context.Context, id string) (widget, bool) {
+		if id == "slow" {
+			<-ctx.Done()
+			return widget{}, false
+		}
+		return store.find(id)
+	}
+	app := serve(api)
+
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/slow", "")
+	expectStatus(t, resp, body, fiber.StatusGatewayTimeout)
+
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/a", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+}
+
+func TestPutCreatesWithPathID(t *testing.T) {
+	store := newWidgetStore()
+	var created string
+	api := widgetApi(store)
+	api.PutCreatesWithPathID = true
+	api.CreateWithID = func(id string, d widgetDto) (widget, error) {
+		created = id
+		d.ID = id
+		return store.create(d)
+	}
+	app := serve(api)
+
+	resp, body := call(t, app, fiber.MethodPut, "/widgets/new", `{"name":"fresh"}`)
+	expectStatus(t, resp, body, fiber.StatusCreated)
+	if created != "new" {
+		t.Errorf("CreateWithID got id %q, want new", created)
+	}
+	if location := resp.Header.Get(fiber.HeaderLocation); location != "/widgets/new" {
+		t.Errorf("Location %q, want /widgets/new", location)
+	}
+
+	// The item now exists, so the PUT updates it
+	resp, body = call(t, app, fiber.MethodPut, "/widgets/new", `{"name":"updated"}`)
+	expectStatus(t, resp, body, fiber.StatusOK)
+
+	api.PutCreatesWithPathID = false
+	resp, body = call(t, serve(api), fiber.MethodPut, "/widgets/other", `{"name":"fresh"}`)
+	expectStatus(t, resp, body, fiber.StatusNotFound)
+}
+
+func TestMarshal(t *testing.T) {
+	api := widgetApi(newWidgetStore(widget{ID: "a", Name: "alpha"}))
+	api.Marshal = func(v any) ([]byte, error) {
+		return []byte(`{"custom":"encoding"}`), nil
+	}
+	app := serve(api)
+
+	for _, target := range []string{"/widgets/a", "/widgets/"} {
+		resp, body := call(t, app, fiber.MethodGet, target, "")
+		expectStatus(t, resp, body, fiber.StatusOK)
+		if body != `{"custom":"encoding"}` {
+			t.Errorf("GET %s: body %s, want the output of Marshal", target, body)
+		}
+		if ctype := resp.Header.Get(fiber.HeaderContentType); ctype != fiber.MIMEApplicationJSON {
+			t.Errorf("GET %s: content type %q", target, ctype)
+		}
+	}
+}
+
+func TestCollectionETagHash(t *testing.T) {
+	store := newWidgetStore(widget{ID: "a", Name: "alpha"})
+	api := widgetApi(store)
+	api.EnableCollectionETag = true
+	app := serve(api)
+
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	etag := resp.Header.Get(fiber.HeaderETag)
+	if !strings.HasPrefix(etag, "W/") {
+		t.Fatalf("ETag %q of a hashed collection is not weak", etag)
+	}
+
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/", "", fiber.HeaderIfNoneMatch, etag)
+	expectStatus(t, resp, body, fiber.StatusNotModified)
+
+	store.create(widgetDto{ID: "b", Name: "beta"})
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/", "", fiber.HeaderIfNoneMatch, etag)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if resp.Header.Get(fiber.HeaderETag) == etag {
+		t.Errorf("ETag %q unchanged by a change to the collection", etag)
+	}
+}
+
+func TestCollectionETagVersion(t *testing.T) {
+	store := newWidgetStore(widget{ID: "a", Name: "alpha"})
+	found := 0
+	api := widgetApi(store)
+	api.FindAll = func() []widget {
+		found++
+		return store.findAll()
+	}
+	api.EnableCollectionETag = true
+	api.CollectionVersion = func() string {
+		return "v1"
+	}
+	app := serve(api)
+
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if etag := resp.Header.Get(fiber.HeaderETag); etag != `"v1"` {
+		t.Fatalf("ETag %q, want the CollectionVersion", etag)
+	}
+
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/", "", fiber.HeaderIfNoneMatch, `"v1"`)
+	expectStatus(t, resp, body, fiber.StatusNotModified)
+	if found != 1 {
+		t.Errorf("FindAll called %d times, the 304 should not find the collection", found)
+	}
+}
+
+func TestDeleteN(t *testing.T) {
+	store := newWidgetStore(widget{ID: "a"}, widget{ID: "b"})
+	api := widgetApi(store)
+	resp, body := call(t, serve(api), fiber.MethodDelete, "/widgets/a", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	var deleted map[string]any
+	decodeBody(t, body, &deleted)
+	if deleted["status"] != "deleted" || deleted["id"] != "a" {
+		t.Errorf("Delete body %s, want the deleted status and id", body)
+	}
+
+	api.DeleteN = func(w widget) (int, error) {
+		store.delete(w)
+		return 3, nil
+	}
+	resp, body = call(t, serve(api), fiber.MethodDelete, "/widgets/b", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if body != `{"deleted":3}` {
+		t.Errorf("DeleteN body %s, want the affected count", body)
+	}
+	if _, ok := store.find("b"); ok {
+		t.Error("DeleteN was not called")
+	}
+}
+
+func TestMaxSearchResults(t *testing.T) {
+	store := newWidgetStore(numberedWidgets(5)...)
+	api := widgetApi(store)
+	api.Search = store.search
+	api.MaxSearchResults = 3
+
+	resp, body := call(t, serve(api), fiber.MethodPost, "/widgets/filter", `{"status":"active"}`)
+	expectStatus(t, resp, body, fiber.StatusUnprocessableEntity)
+
+	api.TruncateSearch = true
+	resp, body = call(t, serve(api), fiber.MethodPost, "/widgets/filter", `{"status":"active"}`)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if resp.Header.Get(HeaderResultsTruncated) != "true" {
+		t.Errorf("%s header missing from truncated results", HeaderResultsTruncated)
+	}
+	var found []widgetDto
+	decodeBody(t, body, &found)
+	if len(found) != 3 {
+		t.Errorf("%d results, want them truncated to 3", len(found))
+	}
+
+	api.MaxSearchResults = 0
+	resp, body = call(t, serve(api), fiber.MethodPost, "/widgets/filter", `{"status":"active"}`)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if resp.Header.Get(HeaderResultsTruncated) != "" {
+		t.Errorf("%s header set without a cap", HeaderResultsTruncated)
+	}
+}
+
+func TestStrongConsistency(t *testing.T) {
+	primary := newWidgetStore(widget{ID: "a", Name: "primary"})
+	replica := newWidgetStore(widget{ID: "a", Name: "replica"})
+	api := widgetApi(primary)
+	api.FindReplica = replica.find
+	api.FindAllReplica = replica.findAll
+	app := serve(api)
+
+	tests := []struct {
+		target string
+		header []string
+		want   string
+	}{
+		{target: "/widgets/a", want: "replica"},
+		{target: "/widgets/a", header: []string{DefaultConsistencyHeader, "strong"}, want: "primary"},
+		{target: "/widgets/", want: "replica"},
+		{target: "/widgets/", header: []string{DefaultConsistencyHeader, "strong"}, want: "primary"},
+	}
+	for _, tt := range tests {
+		resp, body := call(t, app, fiber.MethodGet, tt.target, "", tt.header...)
+		expectStatus(t, resp, body, fiber.StatusOK)
+		if !strings.Contains(body, `"name":"`+tt.want+`"`) {
+			t.Errorf("GET %s %v: %s, want the %s", tt.target, tt.header, body, tt.want)
+		}
+	}
+
+	api.ConsistencyHeader = "X-Read"
+	resp, body := call(t, serve(api), fiber.MethodGet, "/widgets/a", "", "X-Read", "strong")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if !strings.Contains(body, `"name":"primary"`) {
+		t.Errorf("the ConsistencyHeader did not read the primary: %s", body)
+	}
+}
+
+func TestRequireJSONContentType(t *testing.T) {
+	store := newWidgetStore(widget{ID: "a", Name: "alpha"})
+	api := widgetApi(store)
+	api.Search = store.search
+	api.RequireJSONContentType = true
+	app := serve(api)
+
+	tests := []struct {
+		method string
+		target string
+		status int
+	}{
+		{method: fiber.MethodPost, target: "/widgets/", status: fiber.StatusOK},
+		{method: fiber.MethodPut, target: "/widgets/a", status: fiber.StatusOK},
+		{method: fiber.MethodPost, target: "/widgets/filter", status: fiber.StatusOK},
+	}
+	for _, tt := range tests {
+		body := `{"id":"b","name":"beta"}`
+		resp, got := call(t, app, tt.method, tt.target, body, fiber.HeaderContentType, fiber.MIMETextPlain)
+		expectStatus(t, resp, got, fiber.StatusUnsupportedMediaType)
+		resp, got = call(t, app, tt.method, tt.target, body, fiber.HeaderContentType, "application/json; charset=utf-8")
+		expectStatus(t, resp, got, tt.status)
+	}
+}
+
+// part is a child of a widget, exposed as a SubEntity
+type part struct {
+	ID     string `json:"id"`
+	Widget string `json:"widget"`
+}
+
+// widgetParts returns the parts of a widget, two per widget
+func widgetParts(w widget) []any {
+	return []any{part{ID: w.ID + "-1", Widget: w.ID}, part{ID: w.ID + "-2", Widget: w.ID}}
+}
+
+func TestSubEntityDisabled(t *testing.T) {
+	api := widgetApi(newWidgetStore(widget{ID: "a"}))
+	api.SubEntities = []SubEntity[widget, widgetDto]{
+		{SubPath: "parts", Get: widgetParts},
+		{SubPath: "hidden", Get: widgetParts, Disabled: true},
+	}
+	app := serve(api)
+
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/a/parts", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/a/hidden", "")
+	expectStatus(t, resp, body, fiber.StatusNotFound)
+}
+
+func TestPatch(t *testing.T) {
+	store := newWidgetStore(widget{ID: "a", Name: "alpha", Status: "active", Owner: "ann"})
+	api := widgetApi(store)
+	api.ApplyPatch = func(w widget) (widget, error) {
+		store.items[w.ID] = w
+		return w, nil
+	}
+	api.ImmutableFields = []string{"id"}
+	app := serve(api)
+	patch := func(ops string) (*http.Response, string) {
+		return call(t, app, fiber.MethodPatch, "/widgets/a", ops, fiber.HeaderContentType, MIMEApplicationJSONPatch)
+	}
+
+	resp, body := patch(`[{"op":"test","path":"/name","value":"alpha"},{"op":"replace","path":"/name","value":"beta"},{"op":"remove","path":"/status"}]`)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if w := store.items["a"]; w.Name != "beta" || w.Status != "" || w.Owner != "ann" {
+		t.Errorf("patched %+v", w)
+	}
+
+	resp, body = patch(`[{"op":"test","path":"/name","value":"alpha"}]`)
+	expectStatus(t, resp, body, fiber.StatusConflict)
+
+	// The owner is not in the Dto, so it can neither be probed nor changed
+	resp, body = patch(`[{"op":"test","path":"/owner","value":"ann"}]`)
+	expectStatus(t, resp, body, fiber.StatusUnprocessableEntity)
+	resp, body = patch(`[{"op":"replace","path":"/owner","value":"bob"}]`)
+	expectStatus(t, resp, body, fiber.StatusUnprocessableEntity)
+
+	resp, body = patch(`[{"op":"replace","path":"/id","value":"b"}]`)
+	expectStatus(t, resp, body, fiber.StatusBadRequest)
+	resp, body = patch(`[{"op":"replace","path":"/missing","value":"x"}]`)
+	expectStatus(t, resp, body, fiber.StatusUnprocessableEntity)
+	resp, body = call(t, app, fiber.MethodPatch, "/widgets/a", `[]`)
+	expectStatus(t, resp, body, fiber.StatusUnsupportedMediaType)
+	if w := store.items["a"]; w.ID != "a" || w.Owner != "ann" {
+		t.Errorf("rejected patches changed %+v", w)
+	}
+}
+
+func TestDeleteAll(t *testing.T) {
+	store := newWidgetStore(numberedWidgets(4)...)
+	api := widgetApi(store)
+	api.DeleteAll = func(c *fiber.Ctx) (int, error) {
+		n := len(store.items)
+		store.items = map[string]widget{}
+		return n, nil
+	}
+	app := serve(api)
+
+	resp, body := call(t, app, fiber.MethodDelete, "/widgets/", "")
+	expectStatus(t, resp, body, fiber.StatusBadRequest)
+	if len(store.items) != 4 {
+		t.Fatal("deleted all without ?confirm=true")
+	}
+
+	resp, body = call(t, app, fiber.MethodDelete, "/widgets/?confirm=true", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if body != `{"deleted":4}` {
+		t.Errorf("body %s, want the deleted count", body)
+	}
+
+	api.Validator = func(c *fiber.Ctx, action Action, item ...widget) bool {
+		return action != ActionDeleteAll
+	}
+	resp, body = call(t, serve(api), fiber.MethodDelete, "/widgets/?confirm=true", "")
+	expectStatus(t, resp, body, fiber.StatusUnauthorized)
+}
+
+func TestEnrich(t *testing.T) {
+	api := widgetApi(newWidgetStore(widget{ID: "a", Name: "alpha"}, widget{ID: "b", Name: "beta"}))
+	api.Enrich = func(c *fiber.Ctx, w widget) widget {
+		w.Status = "enriched " + w.ID
+		return w
+	}
+	var transformed []string
+	api.Dto = func(w widget) widgetDto {
+		transformed = append(transformed, w.Status)
+		return toWidgetDto(w)
+	}
+	app := serve(api)
+
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/a", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if want := []string{"enriched a", "enriched a", "enriched b"}; !slices.Equal(transformed, want) {
+		t.Errorf("Dto got statuses %q, want %q", transformed, want)
+	}
+}
+
+func TestIdempotentDelete(t *testing.T) {
+	api := widgetApi(newWidgetStore())
+	resp, body := call(t, serve(api), fiber.MethodDelete, "/widgets/gone", "")
+	expectStatus(t, resp, body, fiber.StatusNotFound)
+
+	api.IdempotentDelete = true
+	resp, body = call(t, serve(api), fiber.MethodDelete, "/widgets/gone", "")
+	expectStatus(t, resp, body, fiber.StatusNoContent)
+
+	// A denied caller still cannot tell whether the item exists
+	api.Validator = func(c *fiber.Ctx, action Action, item ...widget) bool {
+		return false
+	}
+	resp, body = call(t, serve(api), fiber.MethodDelete, "/widgets/gone", "")
+	expectStatus(t, resp, body, fiber.StatusUnauthorized)
+}
+
+func TestSubEntityDto(t *testing.T) {
+	api := widgetApi(newWidgetStore(widget{ID: "a"}))
+	api.SubEntities = []SubEntity[widget, widgetDto]{{
+		SubPath: "parts",
+		Get:     widgetParts,
+		Dto: func(child any) any {
+			return map[string]string{"part": child.(part).ID}
+		},
+	}}
+
+	resp, body := call(t, serve(api), fiber.MethodGet, "/widgets/a/parts", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if body != `[{"part":"a-1"},{"part":"a-2"}]` {
+		t.Errorf("body %s, want every part transformed", body)
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	api := widgetApi(newWidgetStore(
+		widget{ID: "a", Status: "active"}, widget{ID: "b", Status: "archived"}, widget{ID: "c", Status: "active"}))
+	api.GroupBy = []GroupByResource[widget]{{
+		SubPath: "status",
+		KeyFn: func(w widget) string {
+			return w.Status
+		},
+		Reduce: func(items []widget) any {
+			return len(items)
+		},
+	}}
+
+	resp, body := call(t, serve(api), fiber.MethodGet, "/widgets/group/status", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if body != `{"active":2,"archived":1}` {
+		t.Errorf("body %s, want the count by status", body)
+	}
+
+	api.Validator = func(c *fiber.Ctx, action Action, item ...widget) bool {
+		return action != ActionGetAll
+	}
+	resp, body = call(t, serve(api), fiber.MethodGet, "/widgets/group/status", "")
+	expectStatus(t, resp, body, fiber.StatusUnauthorized)
+}
+
+func TestMeta(t *testing.T) {
+	type meta struct {
+		Sortable   []string `json:"sortable"`
+		Filterable []string `json:"filterable"`
+		Actions    []string `json:"actions"`
+	}
+	store := newWidgetStore()
+
+	readOnly := Api[widget, widgetDto]{Path: "widgets", Find: store.find, FindAll: store.findAll, Dto: toWidgetDto}
+	resp, body := call(t, serve(readOnly), fiber.MethodGet, "/widgets/_meta", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	var got meta
+	decodeBody(t, body, &got)
+	if len(got.Sortable) != 0 || len(got.Filterable) != 0 || !slices.Equal(got.Actions, []string{"getAll", "getOne"}) {
+		t.Errorf("read only meta %s", body)
+	}
+
+	api := widgetApi(store)
+	api.SearchQuery = func(filter widgetDto, opts QueryOptions) Page[widget] {
+		return pageOf(store.search(filter), int64(opts.Page), int64(opts.Size))
+	}
+	api.SortableFields = []string{"name"}
+	api.AllowedFilterFields = []string{"name", "status"}
+	resp, body = call(t, serve(api), fiber.MethodGet, "/widgets/_meta", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	got = meta{}
+	decodeBody(t, body, &got)
+	if !slices.Equal(got.Sortable, []string{"name"}) || !slices.Equal(got.Filterable, []string{"name", "status"}) {
+		t.Errorf("meta %s, want the sortable and allowed filter fields", body)
+	}
+	for _, action := range []string{"getAll", "getOne", "create", "mutate", "delete"} {
+		if !slices.Contains(got.Actions, action) {
+			t.Errorf("meta actions %q lack %s", got.Actions, action)
+		}
+	}
+}
+
+// syncBuffer is a buffer safe to log to from the handlers while the test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	// Enough data to fill the socket buffers of a client that reads nothing
+	var all []widget
+	name := strings.Repeat("x", 1024)
+	for i := 0; i < 50000; i++ {
+		all = append(all, widget{ID: fmt.Sprint(i), Name: name})
+	}
+	var logged syncBuffer
+	api := Api[widget, widgetDto]{
+		Path:              "widgets",
+		FindAll:           func() []widget { return all },
+		Dto:               toWidgetDto,
+		AllowExport:       true,
+		StreamIdleTimeout: 50 * time.Millisecond,
+		Logger:            slog.New(slog.NewTextHandler(&logged, nil)),
+	}
+	app := serve(api)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	defer app.Shutdown()
+
+	// A client that sends the request and then stalls
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET /widgets/export HTTP/1.1\r\nHost: test\r\n\r\n")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(logged.String(), "Export to") {
+		if time.Now().After(deadline) {
+			t.Fatalf("the stalled export was not aborted, logged: %s", logged.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLinks(t *testing.T) {
+	api := widgetApi(newWidgetStore(widget{ID: "a", Name: "alpha", Owner: "ann"}))
+	api.Links = func(w widget) map[string]string {
+		return map[string]string{"owner": "/users/" + w.Owner}
+	}
+
+	resp, body := call(t, serve(api), fiber.MethodGet, "/widgets/a", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	var got struct {
+		widgetDto
+		Links map[string]string `json:"_links"`
+	}
+	decodeBody(t, body, &got)
+	if got.ID != "a" || got.Name != "alpha" || got.Links["owner"] != "/users/ann" {
+		t.Errorf("body %s, want the links alongside the Dto", body)
+	}
+}
+
+// matches reports if w has the non empty fields of the filter
+func matches(w widget, filter widgetDto) bool {
+	return (filter.Name == "" || w.Name == filter.Name) && (filter.Status == "" || w.Status == filter.Status)
+}
+
+func TestSearchBool(t *testing.T) {
+	store := newWidgetStore(
+		widget{ID: "a", Name: "alpha", Status: "active"},
+		widget{ID: "b", Name: "alpha", Status: "archived"},
+		widget{ID: "c", Name: "gamma", Status: "active"},
+		widget{ID: "d", Name: "delta", Status: "draft"})
+	api := widgetApi(store)
+	// Every And clause and, if there are any, one of the Or clauses must match
+	api.SearchBool = func(query BoolQuery[widgetDto]) []widget {
+		var found []widget
+		for _, w := range store.findAll() {
+			ok := true
+			for _, clause := range query.And {
+				ok = ok && matches(w, clause)
+			}
+			if len(query.Or) > 0 {
+				ok = ok && slices.ContainsFunc(query.Or, func(clause widgetDto) bool { return matches(w, clause) })
+			}
+			if ok {
+				found = append(found, w)
+			}
+		}
+		return found
+	}
+	app := serve(api)
+
+	tests := []struct {
+		query  string
+		status int
+		ids    []string
+	}{
+		{query: `{"and":[{"name":"alpha"}],"or":[{"status":"active"},{"status":"archived"}]}`, status: fiber.StatusOK, ids: []string{"a", "b"}},
+		{query: `{"and":[{"status":"active"}],"or":[{"name":"gamma"},{"name":"delta"}]}`, status: fiber.StatusOK, ids: []string{"c"}},
+		{query: `{"or":[{"status":"draft"}]}`, status: fiber.StatusOK, ids: []string{"d"}},
+		{query: `{"and":{"name":"alpha"}}`, status: fiber.StatusBadRequest},
+		{query: `{"not":[{"name":"alpha"}]}`, status: fiber.StatusBadRequest},
+		{query: `{}`, status: fiber.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		resp, body := call(t, app, fiber.MethodPost, "/widgets/filter", tt.query)
+		expectStatus(t, resp, body, tt.status)
+		if tt.status != fiber.StatusOK {
+			continue
+		}
+		var found []widgetDto
+		decodeBody(t, body, &found)
+		var ids []string
+		for _, w := range found {
+			ids = append(ids, w.ID)
+		}
+		if !slices.Equal(ids, tt.ids) {
+			t.Errorf("%s found %q, want %q", tt.query, ids, tt.ids)
+		}
+	}
+}
+
+func TestPageRenderer(t *testing.T) {
+	store := newWidgetStore(numberedWidgets(3)...)
+	api := widgetApi(store)
+	api.SearchQuery = func(filter widgetDto, opts QueryOptions) Page[widget] {
+		return pageOf(store.search(filter), int64(opts.Page), int64(opts.Size))
+	}
+	api.PageRenderer = func(page Page[widgetDto]) any {
+		return fiber.Map{"page": page.CurrentPage, "perPage": page.PageSize, "total": page.Total, "items": page.Data}
+	}
+	app := serve(api)
+
+	for _, req := range []struct{ method, target, body string }{
+		{method: fiber.MethodGet, target: "/widgets/page/1?size=2"},
+		{method: fiber.MethodPost, target: "/widgets/filter?size=2", body: `{"status":"active"}`},
+	} {
+		resp, body := call(t, app, req.method, req.target, req.body)
+		expectStatus(t, resp, body, fiber.StatusOK)
+		var got struct {
+			Page    int64       `json:"page"`
+			PerPage int64       `json:"perPage"`
+			Total   int64       `json:"total"`
+			Items   []widgetDto `json:"items"`
+			Data    any         `json:"data"`
+		}
+		decodeBody(t, body, &got)
+		if got.Page != 1 || got.PerPage != 2 || got.Total != 3 || len(got.Items) != 2 || got.Data != nil {
+			t.Errorf("%s %s: body %s, want the renamed envelope", req.method, req.target, body)
+		}
+	}
+}
+
+func TestSingleflight(t *testing.T) {
+	store := newWidgetStore(widget{ID: "a", Name: "alpha"})
+	release := make(chan struct{})
+	api := widgetApi(store)
+	api.Find = func(id string) (widget, bool) {
+		w, ok := store.find(id)
+		<-release
+		return w, ok
+	}
+	api.Singleflight = true
+	api.Validator = func(c *fiber.Ctx, action Action, item ...widget) bool {
+		return c.Get("X-User") != "mallory"
+	}
+	app := serve(api)
+
+	// Found and missing lookups are shared, but each caller is still checked
+	requests := []struct {
+		user   string
+		id     string
+		status int
+	}{
+		{user: "ann", id: "a", status: fiber.StatusOK},
+		{user: "bob", id: "a", status: fiber.StatusOK},
+		{user: "mallory", id: "a", status: fiber.StatusUnauthorized},
+		{user: "carl", id: "a", status: fiber.StatusOK},
+		{user: "ann", id: "missing", status: fiber.StatusNotFound},
+		{user: "mallory", id: "missing", status: fiber.StatusUnauthorized},
+		{user: "bob", id: "missing", status: fiber.StatusNotFound},
+	}
+	statuses := make([]int, len(requests))
+	var wg sync.WaitGroup
+	for i, req := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, _ := call(t, app, fiber.MethodGet, "/widgets/"+req.id, "", "X-User", req.user)
+			statuses[i] = resp.StatusCode
+		}()
+	}
+	// Let every request join the lookups before they complete
+	for {
+		store.mu.Lock()
+		finds := store.finds
+		store.mu.Unlock()
+		if finds == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if store.finds != 2 {
+		t.Errorf("%d Find calls, want one for each id", store.finds)
+	}
+	for i, req := range requests {
+		if statuses[i] != req.status {
+			t.Errorf("%s got %d for %s, want %d", req.user, statuses[i], req.id, req.status)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var ran []string
+	api := widgetApi(newWidgetStore(widget{ID: "a"}))
+	api.Middleware = map[Action][]fiber.Handler{
+		ActionGetOne: {func(c *fiber.Ctx) error {
+			ran = append(ran, c.Method()+" "+c.Path())
+			return c.Next()
+		}},
+		ActionDelete: {func(c *fiber.Ctx) error {
+			return c.SendStatus(fiber.StatusTeapot)
+		}},
+	}
+	api.SubEntities = []SubEntity[widget, widgetDto]{{SubPath: "parts", Get: widgetParts}}
+	app := serve(api)
+
+	for _, target := range []string{"/widgets/a", "/widgets/a/parts", "/widgets/"} {
+		resp, body := call(t, app, fiber.MethodGet, target, "")
+		expectStatus(t, resp, body, fiber.StatusOK)
+	}
+	if want := []string{"GET /widgets/a", "GET /widgets/a/parts"}; !slices.Equal(ran, want) {
+		t.Errorf("getOne middleware ran for %q, want %q", ran, want)
+	}
+
+	resp, body := call(t, app, fiber.MethodDelete, "/widgets/a", "")
+	expectStatus(t, resp, body, fiber.StatusTeapot)
+}
+
+func TestCreateWithClientID(t *testing.T) {
+	store := newWidgetStore(widget{ID: "taken"})
+	var created []string
+	api := widgetApi(store)
+	api.CreateWithID = func(id string, d widgetDto) (widget, error) {
+		created = append(created, id)
+		d.ID = id
+		return store.create(d)
+	}
+	app := serve(api)
+
+	id := "4f9c6a52-1f1e-4d3a-9d7e-3b0c2f7f5a10"
+	resp, body := call(t, app, fiber.MethodPost, "/widgets/", `{"id":"`+id+`","name":"fresh"}`)
+	expectStatus(t, resp, body, fiber.StatusCreated)
+	if location := resp.Header.Get(fiber.HeaderLocation); location != "/widgets/"+id {
+		t.Errorf("Location %q, want the client id", location)
+	}
+
+	resp, body = call(t, app, fiber.MethodPost, "/widgets/", `{"id":"taken","name":"again"}`)
+	expectStatus(t, resp, body, fiber.StatusConflict)
+	if !slices.Equal(created, []string{id}) {
+		t.Errorf("CreateWithID got %q, want only the new id", created)
+	}
+
+	// Without CreateWithID the id is left to Create
+	api.CreateWithID = nil
+	resp, body = call(t, serve(api), fiber.MethodPost, "/widgets/", `{"id":"b","name":"beta"}`)
+	expectStatus(t, resp, body, fiber.StatusOK)
+}
+
+// ids returns the ids of the widgets of a json array body
+func ids(t *testing.T, body string) []string {
+	t.Helper()
+	var found []widgetDto
+	decodeBody(t, body, &found)
+	ids := []string{}
+	for _, w := range found {
+		ids = append(ids, w.ID)
+	}
+	return ids
+}
+
+func TestTrash(t *testing.T) {
+	store := newWidgetStore(
+		widget{ID: "a", Status: "active"}, widget{ID: "b", Status: "deleted"}, widget{ID: "c", Status: "active"})
+	withStatus := func(deleted bool) func() []widget {
+		return func() []widget {
+			var found []widget
+			for _, w := range store.findAll() {
+				if (w.Status == "deleted") == deleted {
+					found = append(found, w)
+				}
+			}
+			return found
+		}
+	}
+	api := widgetApi(store)
+	api.FindAll = withStatus(false)
+	api.FindDeleted = withStatus(true)
+	app := serve(api)
+
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/trash", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if got := ids(t, body); !slices.Equal(got, []string{"b"}) {
+		t.Errorf("trash %q, want only the deleted item", got)
+	}
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if got := ids(t, body); !slices.Equal(got, []string{"a", "c"}) {
+		t.Errorf("collection %q, want only the active items", got)
+	}
+
+	api.Validator = func(c *fiber.Ctx, action Action, item ...widget) bool {
+		return action != ActionViewTrash
+	}
+	resp, body = call(t, serve(api), fiber.MethodGet, "/widgets/trash", "")
+	expectStatus(t, resp, body, fiber.StatusUnauthorized)
+}
+
+func TestReturnDiff(t *testing.T) {
+	api := widgetApi(newWidgetStore(widget{ID: "a", Name: "alpha", Status: "active"}))
+	api.ReturnDiff = true
+
+	resp, body := call(t, serve(api), fiber.MethodPut, "/widgets/a", `{"id":"a","name":"beta","status":"active"}`)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	var got struct {
+		widgetDto
+		Changes map[string]FieldChange `json:"_changes"`
+	}
+	decodeBody(t, body, &got)
+	want := map[string]FieldChange{"name": {Old: "alpha", New: "beta"}}
+	if got.Name != "beta" || !reflect.DeepEqual(got.Changes, want) {
+		t.Errorf("body %s, want only the name in the changes", body)
+	}
+}
+
+func TestGroupFallback(t *testing.T) {
+	api := widgetApi(newWidgetStore(widget{ID: "a"}))
+	api.GroupFallback = true
+	app := serve(api)
+
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/a/nonexistent", "")
+	expectStatus(t, resp, body, fiber.StatusNotFound)
+	if body != `{"error":"Not Found"}` {
+		t.Errorf("body %s, want the json 404", body)
+	}
+	if ctype := resp.Header.Get(fiber.HeaderContentType); ctype != fiber.MIMEApplicationJSON {
+		t.Errorf("content type %q", ctype)
+	}
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/a", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+
+	// A sibling Api whose path the fallback Api's is a prefix of is still reached
+	siblings := fiber.New()
+	RegisterAPI(siblings, api)
+	sibling := widgetApi(newWidgetStore(widget{ID: "x"}))
+	sibling.Path = "widgets2"
+	RegisterAPI(siblings, sibling)
+	resp, body = call(t, siblings, fiber.MethodGet, "/widgets2/x", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	resp, body = call(t, siblings, fiber.MethodGet, "/widgets2/x/nonexistent", "")
+	expectStatus(t, resp, body, fiber.StatusNotFound)
+	if body == `{"error":"Not Found"}` {
+		t.Error("the fallback of widgets answered for widgets2")
+	}
+	resp, body = call(t, siblings, fiber.MethodGet, "/widgets", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+
+	api.GroupFallback = false
+	resp, body = call(t, serve(api), fiber.MethodGet, "/widgets/a/nonexistent", "")
+	expectStatus(t, resp, body, fiber.StatusNotFound)
+	if body == `{"error":"Not Found"}` {
+		t.Error("the fallback answered without GroupFallback")
+	}
+}
+
+func TestSubEntityTotalCount(t *testing.T) {
+	api := widgetApi(newWidgetStore(widget{ID: "a"}))
+	api.SubEntities = []SubEntity[widget, widgetDto]{{SubPath: "parts", Get: widgetParts}}
+
+	resp, body := call(t, serve(api), fiber.MethodGet, "/widgets/a/parts", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if count := resp.Header.Get(HeaderTotalCount); count != "2" {
+		t.Errorf("%s %q, want 2", HeaderTotalCount, count)
+	}
+}
+
+func TestStatusMapper(t *testing.T) {
+	api := widgetApi(newWidgetStore(widget{ID: "a"}))
+	var mapped []Action
+	api.StatusMapper = func(action Action, status int) int {
+		mapped = append(mapped, action)
+		if status == fiber.StatusNotFound {
+			return fiber.StatusGone
+		}
+		return status
+	}
+	app := serve(api)
+
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/missing", "")
+	expectStatus(t, resp, body, fiber.StatusGone)
+	resp, body = call(t, app, fiber.MethodDelete, "/widgets/missing", "")
+	expectStatus(t, resp, body, fiber.StatusGone)
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/a", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if want := []Action{ActionGetOne, ActionDelete, ActionGetOne}; !slices.Equal(mapped, want) {
+		t.Errorf("StatusMapper got %v, want %v", mapped, want)
+	}
+}
+
+func TestStatusMapperErrors(t *testing.T) {
+	api := widgetApi(newWidgetStore(widget{ID: "a"}))
+	// The serializer fails, so the handler returns its error for the error handler to answer
+	api.Marshal = func(v any) ([]byte, error) {
+		return nil, errors.New("cannot encode")
+	}
+	var statuses []int
+	api.StatusMapper = func(action Action, status int) int {
+		statuses = append(statuses, status)
+		if status == fiber.StatusInternalServerError {
+			return fiber.StatusServiceUnavailable
+		}
+		return status
+	}
+	app := serve(api)
+
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/a", "")
+	expectStatus(t, resp, body, fiber.StatusServiceUnavailable)
+	if !slices.Equal(statuses, []int{fiber.StatusInternalServerError}) {
+		t.Errorf("StatusMapper got %v, want the 500 of the error", statuses)
+	}
+
+	// An unmapped error is left as is
+	api.StatusMapper = func(action Action, status int) int { return status }
+	resp, body = call(t, serve(api), fiber.MethodGet, "/widgets/a", "")
+	expectStatus(t, resp, body, fiber.StatusInternalServerError)
+}
+
+func TestDtoWithIncludes(t *testing.T) {
+	scored := 0
+	api := widgetApi(newWidgetStore(widget{ID: "a", Name: "alpha"}, widget{ID: "b", Name: "beta"}))
+	api.DtoWithIncludes = func(w widget, includes map[string]bool) widgetDto {
+		d := toWidgetDto(w)
+		if includes["score"] {
+			scored++
+			d.Status = "scored"
+		}
+		return d
+	}
+	app := serve(api)
+
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/a", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/?include=owner", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if scored != 0 || strings.Contains(body, "scored") {
+		t.Fatalf("the unrequested score was computed: %s", body)
+	}
+
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/?include=owner,score", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if scored != 2 {
+		t.Errorf("score computed %d times, want once per item", scored)
+	}
+}
+
+func TestPreprocessBody(t *testing.T) {
+	type counter struct {
+		ID    string `json:"id"`
+		Count int    `json:"count"`
+	}
+	var created counter
+	api := Api[counter, counter]{
+		Path: "counters",
+		Create: func(c counter) (counter, error) {
+			created = c
+			return c, nil
+		},
+		Dto: func(c counter) counter { return c },
+		// Coerce a count sent as a string to a number
+		PreprocessBody: func(raw []byte) ([]byte, error) {
+			var fields map[string]any
+			if err := json.Unmarshal(raw, &fields); err != nil {
+				return nil, err
+			}
+			if count, ok := fields["count"].(string); ok {
+				n, err := strconv.Atoi(count)
+				if err != nil {
+					return nil, err
+				}
+				fields["count"] = n
+			}
+			return json.Marshal(fields)
+		},
+	}
+	app := serve(api)
+
+	resp, body := call(t, app, fiber.MethodPost, "/counters/", `{"id":"a","count":"42"}`)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if created.Count != 42 {
+		t.Errorf("created %+v, want the normalized count", created)
+	}
+	resp, body = call(t, app, fiber.MethodPost, "/counters/", `{"id":"a","count":"many"}`)
+	expectStatus(t, resp, body, fiber.StatusBadRequest)
+}
+
+func TestSubEntityDelete(t *testing.T) {
+	children := map[string]bool{"a-1": true, "a-2": true}
+	api := widgetApi(newWidgetStore(widget{ID: "a"}))
+	api.SubEntities = []SubEntity[widget, widgetDto]{{
+		SubPath: "parts",
+		Get:     widgetParts,
+		Delete: func(parent widget, subID string) error {
+			if !children[subID] {
+				return ErrNotFound
+			}
+			delete(children, subID)
+			return nil
+		},
+	}}
+	app := serve(api)
+
+	resp, body := call(t, app, fiber.MethodDelete, "/widgets/a/parts/a-1", "")
+	expectStatus(t, resp, body, fiber.StatusNoContent)
+	if children["a-1"] {
+		t.Error("the child was not deleted")
+	}
+	resp, body = call(t, app, fiber.MethodDelete, "/widgets/a/parts/a-1", "")
+	expectStatus(t, resp, body, fiber.StatusNotFound)
+	resp, body = call(t, app, fiber.MethodDelete, "/widgets/missing/parts/a-2", "")
+	expectStatus(t, resp, body, fiber.StatusNotFound)
+
+	api.Validator = func(c *fiber.Ctx, action Action, item ...widget) bool {
+		return action != ActionDelete
+	}
+	resp, body = call(t, serve(api), fiber.MethodDelete, "/widgets/a/parts/a-2", "")
+	expectStatus(t, resp, body, fiber.StatusUnauthorized)
+	if !children["a-2"] {
+		t.Error("a denied caller deleted the child")
+	}
+}
+
+func TestDtoBatch(t *testing.T) {
+	store := newWidgetStore(numberedWidgets(5)...)
+	var batches [][]string
+	api := widgetApi(store)
+	api.Search = store.search
+	api.Dto = func(w widget) widgetDto {
+		t.Errorf("Dto called for %s of a collection", w.ID)
+		return toWidgetDto(w)
+	}
+	api.DtoBatch = func(items []widget) []widgetDto {
+		var batch []string
+		var all []widgetDto
+		for _, w := range items {
+			batch = append(batch, w.ID)
+			all = append(all, toWidgetDto(w))
+		}
+		batches = append(batches, batch)
+		return all
+	}
+	app := serve(api)
+
+	for _, req := range []struct{ method, target, body string }{
+		{method: fiber.MethodGet, target: "/widgets/"},
+		{method: fiber.MethodPost, target: "/widgets/filter", body: `{"status":"active"}`},
+		{method: fiber.MethodGet, target: "/widgets/page/1?size=2"},
+	} {
+		batches = nil
+		resp, body := call(t, app, req.method, req.target, req.body)
+		expectStatus(t, resp, body, fiber.StatusOK)
+		if len(batches) != 1 {
+			t.Errorf("%s %s: DtoBatch called %d times, want once", req.method, req.target, len(batches))
+		}
+	}
+	if want := []string{"w01", "w02"}; len(batches) != 1 || !slices.Equal(batches[0], want) {
+		t.Errorf("the page batched %q, want %q", batches, want)
+	}
+}
+
+func TestEnums(t *testing.T) {
+	api := widgetApi(newWidgetStore())
+	api.EnumValues = map[string][]string{"status": {"active", "archived"}, "role": {"admin", "user"}}
+	app := serve(api)
+
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/_enums/status", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if body != `["active","archived"]` {
+		t.Errorf("status values %s", body)
+	}
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/_enums", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if body != `{"role":["admin","user"],"status":["active","archived"]}` {
+		t.Errorf("enums %s", body)
+	}
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/_enums/color", "")
+	expectStatus(t, resp, body, fiber.StatusNotFound)
+}
+
+func TestImmutableFields(t *testing.T) {
+	store := newWidgetStore(widget{ID: "a", Name: "alpha", Status: "active"})
+	api := widgetApi(store)
+	api.ImmutableFields = []string{"id", "status"}
+	app := serve(api)
+
+	resp, body := call(t, app, fiber.MethodPut, "/widgets/a", `{"id":"b","name":"beta","status":"archived"}`)
+	expectStatus(t, resp, body, fiber.StatusBadRequest)
+	var invalid struct {
+		Errors map[string]string `json:"errors"`
+	}
+	decodeBody(t, body, &invalid)
+	if want := map[string]string{"id": "is immutable", "status": "is immutable"}; !reflect.DeepEqual(invalid.Errors, want) {
+		t.Errorf("errors %v, want %v", invalid.Errors, want)
+	}
+	if w := store.items["a"]; w.Name != "alpha" {
+		t.Errorf("the rejected change was applied: %+v", w)
+	}
+
+	// Unchanged or omitted immutable fields do not block a benign change
+	resp, body = call(t, app, fiber.MethodPut, "/widgets/a", `{"id":"a","name":"beta"}`)
+	expectStatus(t, resp, body, fiber.StatusOK)
+}
+
+func TestSlowThreshold(t *testing.T) {
+	store := newWidgetStore(widget{ID: "a"}, widget{ID: "slow"})
+	var logged syncBuffer
+	api := widgetApi(store)
+	api.Find = func(id string) (widget, bool) {
+		if id == "slow" {
+			time.Sleep(30 * time.Millisecond)
+		}
+		return store.find(id)
+	}
+	api.SlowThreshold = 10 * time.Millisecond
+	api.Logger = slog.New(slog.NewTextHandler(&logged, nil))
+	app := serve(api)
+
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/a", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if strings.Contains(logged.String(), "Slow") {
+		t.Fatalf("a fast Find was logged: %s", logged.String())
+	}
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/slow", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if log := logged.String(); !strings.Contains(log, "level=WARN") || !strings.Contains(log, "Slow Find in getOne /widgets/slow") {
+		t.Errorf("the slow Find was not logged: %s", log)
+	}
+}
+
+func TestCanonicalID(t *testing.T) {
+	api := widgetApi(newWidgetStore(widget{ID: "42", Name: "alpha"}))
+	api.CanonicalID = func(id string) (string, bool) {
+		if id == "alpha-widget" {
+			return "42", true
+		}
+		return id, false
+	}
+	app := serve(api)
+
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/alpha-widget?embed=", "")
+	expectStatus(t, resp, body, fiber.StatusMovedPermanently)
+	if location := resp.Header.Get(fiber.HeaderLocation); location != "/widgets/42?embed=" {
+		t.Errorf("Location %q, want the canonical url with the query", location)
+	}
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/42", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+
+	// A denied caller does not learn the canonical id
+	api.Validator = func(c *fiber.Ctx, action Action, item ...widget) bool {
+		return false
+	}
+	resp, body = call(t, serve(api), fiber.MethodGet, "/widgets/alpha-widget", "")
+	expectStatus(t, resp, body, fiber.StatusUnauthorized)
+	if location := resp.Header.Get(fiber.HeaderLocation); location != "" {
+		t.Errorf("Location %q sent to a denied caller", location)
+	}
+}
+
+func TestSubEntityNDJSON(t *testing.T) {
+	api := widgetApi(newWidgetStore(widget{ID: "a"}))
+	api.StreamNDJSON = true
+	api.SubEntities = []SubEntity[widget, widgetDto]{{SubPath: "parts", Get: widgetParts}}
+	app := serve(api)
+
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/a/parts", "", fiber.HeaderAccept, MIMEApplicationNDJSON)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if ctype := resp.Header.Get(fiber.HeaderContentType); ctype != MIMEApplicationNDJSON {
+		t.Errorf("content type %q, want NDJSON", ctype)
+	}
+	lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("%d lines, want one per part: %q", len(lines), body)
+	}
+	for i, line := range lines {
+		var p part
+		decodeBody(t, line, &p)
+		if want := fmt.Sprintf("a-%d", i+1); p.ID != want {
+			t.Errorf("line %d is %s, want part %s", i, line, want)
+		}
+	}
+
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/a/parts", "", fiber.HeaderAccept, fiber.MIMEApplicationJSON)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if !strings.HasPrefix(body, "[") {
+		t.Errorf("body %s, want a json array", body)
+	}
+}
+
+func TestAPIVersion(t *testing.T) {
+	api := widgetApi(newWidgetStore(widget{ID: "a"}))
+	api.APIVersion = "v2"
+	app := serve(api)
+
+	for _, target := range []string{"/v2/widgets/a", "/v2/widgets/", "/v2/widgets/missing"} {
+		resp, _ := call(t, app, fiber.MethodGet, target, "")
+		if version := resp.Header.Get(HeaderAPIVersion); version != "v2" {
+			t.Errorf("GET %s: %s %q, want v2", target, HeaderAPIVersion, version)
+		}
+	}
+	resp, body := call(t, app, fiber.MethodGet, "/v2/widgets/a", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/a", "")
+	expectStatus(t, resp, body, fiber.StatusNotFound)
+
+	// A sibling Api whose path the versioned one is a prefix of is not tagged with its version
+	sibling := widgetApi(newWidgetStore(widget{ID: "x"}))
+	sibling.Path = "v2/widgetsold"
+	RegisterAPI(app, sibling)
+	resp, body = call(t, app, fiber.MethodGet, "/v2/widgetsold/x", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if version := resp.Header.Get(HeaderAPIVersion); version != "" {
+		t.Errorf("sibling GET: %s %q, want none", HeaderAPIVersion, version)
+	}
+}
+
+func TestPreferReturn(t *testing.T) {
+	store := newWidgetStore(widget{ID: "a", Name: "alpha"})
+	app := serve(widgetApi(store))
+
+	tests := []struct {
+		method, target, body string
+		prefer               string
+		status               int
+		applied              string
+	}{
+		{method: fiber.MethodPut, target: "/widgets/a", body: `{"name":"beta"}`, prefer: "return=minimal", status: fiber.StatusNoContent, applied: "return=minimal"},
+		{method: fiber.MethodPut, target: "/widgets/a", body: `{"name":"gamma"}`, prefer: "return=representation", status: fiber.StatusOK, applied: "return=representation"},
+		{method: fiber.MethodPut, target: "/widgets/a", body: `{"name":"delta"}`, status: fiber.StatusOK},
+		{method: fiber.MethodPost, target: "/widgets/", body: `{"id":"b"}`, prefer: "respond-async, return=minimal", status: fiber.StatusNoContent, applied: "return=minimal"},
+		{method: fiber.MethodPost, target: "/widgets/", body: `{"id":"c"}`, prefer: "return=representation", status: fiber.StatusOK, applied: "return=representation"},
+	}
+	for _, tt := range tests {
+		var header []string
+		if tt.prefer != "" {
+			header = []string{HeaderPrefer, tt.prefer}
+		}
+		resp, body := call(t, app, tt.method, tt.target, tt.body, header...)
+		expectStatus(t, resp, body, tt.status)
+		if applied := resp.Header.Get(HeaderPreferenceApplied); applied != tt.applied {
+			t.Errorf("%s %s %q: %s %q, want %q", tt.method, tt.target, tt.prefer, HeaderPreferenceApplied, applied, tt.applied)
+		}
+		if tt.status == fiber.StatusNoContent && body != "" {
+			t.Errorf("%s %s: minimal response has the body %s", tt.method, tt.target, body)
+		}
+		if tt.status == fiber.StatusOK && !strings.Contains(body, `"id"`) {
+			t.Errorf("%s %s: body %s, want the representation", tt.method, tt.target, body)
+		}
+	}
+	if w := store.items["a"]; w.Name != "delta" {
+		t.Errorf("the mutations were not applied: %+v", w)
+	}
+}
+
+// denyPolicy denies one action, and fails to decide on the item with the id broken
+type denyPolicy struct {
+	denied    Action
+	resources []any
+}
+
+func (p *denyPolicy) Allow(c *fiber.Ctx, action Action, resource any) (bool, error) {
+	p.resources = append(p.resources, resource)
+	if w, ok := resource.(widget); ok && w.ID == "broken" {
+		return false, errors.New("policy engine unavailable")
+	}
+	return action != p.denied, nil
+}
+
+func TestAuthPolicy(t *testing.T) {
+	policy := &denyPolicy{denied: ActionDelete}
+	api := widgetApi(newWidgetStore(widget{ID: "a"}, widget{ID: "broken"}))
+	api.AuthPolicy = policy
+	api.Validator = func(c *fiber.Ctx, action Action, item ...widget) bool {
+		t.Error("the Validator was consulted in place of the AuthPolicy")
+		return true
+	}
+	app := serve(api)
+
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/a", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if len(policy.resources) != 1 || policy.resources[0] != (widget{ID: "a"}) {
+		t.Errorf("the policy got %v, want the item", policy.resources)
+	}
+	resp, body = call(t, app, fiber.MethodDelete, "/widgets/a", "")
+	expectStatus(t, resp, body, fiber.StatusUnauthorized)
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/broken", "")
+	expectStatus(t, resp, body, fiber.StatusInternalServerError)
+}
+
+func TestDtoLocalized(t *testing.T) {
+	var locales []string
+	api := widgetApi(newWidgetStore(widget{ID: "a", Name: "alpha"}))
+	api.Dto = nil
+	api.DtoLocalized = func(w widget, locale string) widgetDto {
+		locales = append(locales, locale)
+		d := toWidgetDto(w)
+		d.Name = locale + ":" + w.Name
+		return d
+	}
+	api.DefaultLocale = "en"
+	app := serve(api)
+
+	tests := []struct {
+		target   string
+		language string
+		want     string
+	}{
+		{target: "/widgets/a", language: "fr-CH, fr;q=0.9, en;q=0.8", want: "fr-CH"},
+		{target: "/widgets/a", language: "en;q=0.5, de;q=0.7", want: "de"},
+		{target: "/widgets/a", want: "en"},
+		{target: "/widgets/a", language: "*", want: "en"},
+		{target: "/widgets/", language: "nl", want: "nl"},
+	}
+	for _, tt := range tests {
+		locales = nil
+		var header []string
+		if tt.language != "" {
+			header = []string{fiber.HeaderAcceptLanguage, tt.language}
+		}
+		resp, body := call(t, app, fiber.MethodGet, tt.target, "", header...)
+		expectStatus(t, resp, body, fiber.StatusOK)
+		if !slices.Equal(locales, []string{tt.want}) || !strings.Contains(body, `"name":"`+tt.want+`:alpha"`) {
+			t.Errorf("GET %s %q: transformed in %q, body %s, want %s", tt.target, tt.language, locales, body, tt.want)
+		}
+		if vary := resp.Header.Get(fiber.HeaderVary); !strings.Contains(vary, fiber.HeaderAcceptLanguage) {
+			t.Errorf("GET %s: Vary %q lacks %s", tt.target, vary, fiber.HeaderAcceptLanguage)
+		}
+	}
+}
+
+func TestDtoVariantsConflict(t *testing.T) {
+	api := widgetApi(newWidgetStore())
+	api.DtoLocalized = func(w widget, locale string) widgetDto { return toWidgetDto(w) }
+	api.DtoE = func(w widget) (widgetDto, error) { return toWidgetDto(w), nil }
+	defer func() {
+		r := recover()
+		if message, _ := r.(string); !strings.Contains(message, "DtoE, DtoLocalized") {
+			t.Errorf("registering recovered %v, want a panic naming the conflicting variants", r)
+		}
+	}()
+	serve(api)
+}
+
+func TestRequireIfMatch(t *testing.T) {
+	store := newWidgetStore(widget{ID: "a", Name: "alpha"}, widget{ID: "b", Name: "beta"})
+	api := widgetApi(store)
+	api.ApplyPatch = func(w widget) (widget, error) {
+		store.items[w.ID] = w
+		return w, nil
+	}
+	api.Version = func(w widget) string { return w.Name }
+	api.RequireIfMatch = true
+	app := serve(api)
+	patchType := []string{fiber.HeaderContentType, MIMEApplicationJSONPatch}
+	patch := `[{"op":"replace","path":"/name","value":"gamma"}]`
+
+	tests := []struct {
+		method string
+		body   string
+		header []string
+	}{
+		{method: fiber.MethodPut, body: `{"name":"gamma"}`},
+		{method: fiber.MethodPatch, body: patch, header: patchType},
+		{method: fiber.MethodDelete},
+	}
+	for _, tt := range tests {
+		resp, body := call(t, app, tt.method, "/widgets/a", tt.body, tt.header...)
+		expectStatus(t, resp, body, fiber.StatusPreconditionRequired)
+		resp, body = call(t, app, tt.method, "/widgets/a", tt.body, append([]string{fiber.HeaderIfMatch, `"stale"`}, tt.header...)...)
+		expectStatus(t, resp, body, fiber.StatusPreconditionFailed)
+	}
+	if w := store.items["a"]; w.Name != "alpha" {
+		t.Errorf("rejected writes changed %+v", w)
+	}
+
+	resp, body := call(t, app, fiber.MethodPut, "/widgets/a", `{"name":"gamma"}`, fiber.HeaderIfMatch, `"alpha"`)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	resp, body = call(t, app, fiber.MethodPatch, "/widgets/a", patch, append([]string{fiber.HeaderIfMatch, `"gamma"`}, patchType...)...)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	resp, body = call(t, app, fiber.MethodDelete, "/widgets/b", "", fiber.HeaderIfMatch, `"beta"`)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if _, ok := store.items["b"]; ok {
+		t.Error("DELETE with a matching If-Match kept b")
+	}
+}
+
+func TestCreateWithGeneratedID(t *testing.T) {
+	store := newWidgetStore()
+	next := 0
+	api := widgetApi(store)
+	api.GenerateID = func() string {
+		next++
+		return fmt.Sprintf("gen-%d", next)
+	}
+	api.CreateWithGeneratedID = func(id string, d widgetDto) (widget, error) {
+		d.ID = id
+		return store.create(d)
+	}
+	app := serve(api)
+
+	for _, want := range []string{"gen-1", "gen-2"} {
+		resp, body := call(t, app, fiber.MethodPost, "/widgets/", `{"name":"fresh"}`)
+		expectStatus(t, resp, body, fiber.StatusCreated)
+		if location := resp.Header.Get(fiber.HeaderLocation); location != "/widgets/"+want {
+			t.Errorf("Location %q, want /widgets/%s", location, want)
+		}
+		var got widgetDto
+		decodeBody(t, body, &got)
+		if got.ID != want || store.items[want].Name != "fresh" {
+			t.Errorf("created %s, want the generated id %s", body, want)
+		}
+	}
+
+	// Without CreateWithGeneratedID the id is left to Create
+	api.CreateWithGeneratedID = nil
+	resp, body := call(t, serve(api), fiber.MethodPost, "/widgets/", `{"id":"b","name":"beta"}`)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if next != 2 {
+		t.Errorf("GenerateID called %d times, want 2", next)
+	}
+}
+
+func TestAllowedFilterFields(t *testing.T) {
+	store := newWidgetStore(widget{ID: "a", Status: "active"}, widget{ID: "b", Status: "retired"})
+	searched := 0
+	api := widgetApi(store)
+	api.Search = func(filter widgetDto) []widget {
+		searched++
+		return store.search(filter)
+	}
+	api.AllowedFilterFields = []string{"status"}
+	app := serve(api)
+
+	tests := []struct {
+		method string
+		target string
+		body   string
+	}{
+		{method: fiber.MethodPost, target: "/widgets/filter", body: `{"status":"active","name":"x","id":"a"}`},
+		{method: fiber.MethodGet, target: "/widgets/filter?status=active&name=x&id=a"},
+	}
+	for _, tt := range tests {
+		resp, body := call(t, app, tt.method, tt.target, tt.body)
+		expectStatus(t, resp, body, fiber.StatusBadRequest)
+		var invalid struct {
+			Errors map[string]string `json:"errors"`
+		}
+		decodeBody(t, body, &invalid)
+		if _, ok := invalid.Errors["status"]; ok || len(invalid.Errors) != 2 || invalid.Errors["name"] == "" || invalid.Errors["id"] == "" {
+			t.Errorf("%s %s: errors %v, want the disallowed id and name", tt.method, tt.target, invalid.Errors)
+		}
+	}
+	if searched != 0 {
+		t.Errorf("Search ran %d times for disallowed filters", searched)
+	}
+
+	resp, body := call(t, app, fiber.MethodPost, "/widgets/filter", `{"status":"active"}`)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/filter?status=active", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if got := ids(t, body); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("allowed filter found %q, want [a]", got)
+	}
+}
+
+func TestIsZero(t *testing.T) {
+	api := widgetApi(newWidgetStore())
+	api.Find = func(id string) (widget, bool) {
+		// A buggy Find reporting every id as found
+		return widget{}, true
+	}
+	resp, body := call(t, serve(api), fiber.MethodGet, "/widgets/a", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if body != `{"id":"","name":"","status":""}` {
+		t.Errorf("zero item without IsZero %s, want it serialized", body)
+	}
+
+	api.IsZero = func(w widget) bool { return w == widget{} }
+	app := serve(api)
+	for _, method := range []string{fiber.MethodGet, fiber.MethodDelete} {
+		resp, body = call(t, app, method, "/widgets/a", "")
+		expectStatus(t, resp, body, fiber.StatusNotFound)
+	}
+}
+
+func TestStaleWarnings(t *testing.T) {
+	store := newWidgetStore(numberedWidgets(3)...)
+	found := 0
+	api := cachedApi(store, &found)
+	api.FindReplica = store.find
+	api.StaleWarnings = true
+	app := serve(api)
+
+	tests := []struct {
+		target string
+		header []string
+		want   string
+	}{
+		{target: "/widgets/page/1"},
+		{target: "/widgets/page/1", want: WarningStale},
+		{target: "/widgets/w01", want: WarningReplica},
+		{target: "/widgets/w01", header: []string{DefaultConsistencyHeader, "strong"}},
+	}
+	for _, tt := range tests {
+		resp, body := call(t, app, fiber.MethodGet, tt.target, "", tt.header...)
+		expectStatus(t, resp, body, fiber.StatusOK)
+		if warning := resp.Header.Get(HeaderWarning); warning != tt.want {
+			t.Errorf("GET %s %q: Warning %q, want %q", tt.target, tt.header, warning, tt.want)
+		}
+	}
+
+	api.StaleWarnings = false
+	app = serve(api)
+	for _, target := range []string{"/widgets/page/1", "/widgets/page/1", "/widgets/w01"} {
+		resp, body := call(t, app, fiber.MethodGet, target, "")
+		expectStatus(t, resp, body, fiber.StatusOK)
+		if warning := resp.Header.Get(HeaderWarning); warning != "" {
+			t.Errorf("GET %s: Warning %q without StaleWarnings", target, warning)
+		}
+	}
+}
+
+func TestMaxPageSizeFor(t *testing.T) {
+	store := newWidgetStore(numberedWidgets(3)...)
+	var sizes []int
+	api := widgetApi(store)
+	api.FindAllPageSized = func(page int64, size int) Page[widget] {
+		sizes = append(sizes, size)
+		return pageOf(store.findAll(), page, int64(size))
+	}
+	api.MaxPageSize = 50
+	api.MaxPageSizeFor = func(c *fiber.Ctx) int {
+		switch c.Get("X-Plan") {
+		case "free":
+			return 5
+		case "pro":
+			return 100
+		}
+		return 0
+	}
+	app := serve(api)
+
+	tests := []struct {
+		plan string
+		size string
+		want int
+	}{
+		{plan: "free", size: "20", want: 5},
+		{plan: "free", size: "2", want: 2},
+		{plan: "pro", size: "80", want: 80},
+		{plan: "pro", size: "500", want: 100},
+		{size: "80", want: 50},
+	}
+	for _, tt := range tests {
+		sizes = nil
+		resp, body := call(t, app, fiber.MethodGet, "/widgets/page/1?size="+tt.size, "", "X-Plan", tt.plan)
+		expectStatus(t, resp, body, fiber.StatusOK)
+		if !slices.Equal(sizes, []int{tt.want}) {
+			t.Errorf("plan %q size %s: paged by %v, want %d", tt.plan, tt.size, sizes, tt.want)
+		}
+	}
+}
+
+func TestAncestors(t *testing.T) {
+	// The Owner of a widget is its parent
+	store := newWidgetStore(
+		widget{ID: "root", Name: "root"},
+		widget{ID: "mid", Name: "mid", Owner: "root"},
+		widget{ID: "leaf", Name: "leaf", Owner: "mid"},
+		widget{ID: "orphan", Name: "orphan", Owner: "gone"},
+	)
+	api := widgetApi(store)
+	api.Ancestors = func(w widget) []any {
+		var chain []any
+		for w.Owner != "" {
+			parent, ok := store.items[w.Owner]
+			if !ok {
+				chain = append([]any{w.Owner}, chain...)
+				break
+			}
+			chain = append([]any{parent}, chain...)
+			w = parent
+		}
+		return chain
+	}
+	app := serve(api)
+
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{id: "leaf", want: `[{"id":"root","name":"root","status":""},{"id":"mid","name":"mid","status":""}]`},
+		{id: "root", want: `[]`},
+		{id: "orphan", want: `["gone"]`},
+	}
+	for _, tt := range tests {
+		resp, body := call(t, app, fiber.MethodGet, "/widgets/"+tt.id+"/ancestors", "")
+		expectStatus(t, resp, body, fiber.StatusOK)
+		if body != tt.want {
+			t.Errorf("ancestors of %s %s, want %s", tt.id, body, tt.want)
+		}
+	}
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/missing/ancestors", "")
+	expectStatus(t, resp, body, fiber.StatusNotFound)
+}
+
+func TestAllowEmptyBody(t *testing.T) {
+	store := newWidgetStore(widget{ID: "a", Name: "alpha", Status: "active"})
+	api := widgetApi(store)
+	api.ApplyPatch = func(w widget) (widget, error) { return w, nil }
+	app := serve(api)
+
+	for _, body := range []string{"", " \n"} {
+		resp, got := call(t, app, fiber.MethodPut, "/widgets/a", body)
+		expectStatus(t, resp, got, fiber.StatusBadRequest)
+	}
+	resp, body := call(t, app, fiber.MethodPatch, "/widgets/a", "", fiber.HeaderContentType, MIMEApplicationJSONPatch)
+	expectStatus(t, resp, body, fiber.StatusBadRequest)
+	if w := store.items["a"]; w.Name != "alpha" {
+		t.Errorf("an empty body mutated %+v", w)
+	}
+
+	api.AllowEmptyBody = true
+	resp, body = call(t, serve(api), fiber.MethodPut, "/widgets/a", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if w := store.items["a"]; w.Name != "" || w.Status != "" {
+		t.Errorf("an allowed empty body mutated to %+v, want the zero Dto applied", w)
+	}
+}
+
+func TestDraining(t *testing.T) {
+	store := newWidgetStore(widget{ID: "a", Name: "alpha"})
+	draining := true
+	api := widgetApi(store)
+	api.Draining = func() bool { return draining }
+	app := serve(api)
+
+	writes := []struct {
+		method string
+		target string
+		body   string
+	}{
+		{method: fiber.MethodPost, target: "/widgets/", body: `{"id":"b"}`},
+		{method: fiber.MethodPut, target: "/widgets/a", body: `{"name":"beta"}`},
+		{method: fiber.MethodDelete, target: "/widgets/a"},
+		{method: fiber.MethodPost, target: "/widgets/batch", body: `[{"id":"c"}]`},
+	}
+	for _, tt := range writes {
+		resp, body := call(t, app, tt.method, tt.target, tt.body)
+		expectStatus(t, resp, body, fiber.StatusServiceUnavailable)
+		if resp.Header.Get(fiber.HeaderRetryAfter) == "" {
+			t.Errorf("%s %s while draining lacks a Retry-After", tt.method, tt.target)
+		}
+	}
+	if len(store.items) != 1 || store.items["a"].Name != "alpha" {
+		t.Errorf("writes while draining changed the store %+v", store.items)
+	}
+	for _, target := range []string{"/widgets/a", "/widgets/"} {
+		resp, body := call(t, app, fiber.MethodGet, target, "")
+		expectStatus(t, resp, body, fiber.StatusOK)
+	}
+
+	draining = false
+	resp, body := call(t, app, fiber.MethodPut, "/widgets/a", `{"name":"beta"}`)
+	expectStatus(t, resp, body, fiber.StatusOK)
+}
+
+func TestSearchFacets(t *testing.T) {
+	store := newWidgetStore(
+		widget{ID: "a", Name: "bolt", Status: "active"},
+		widget{ID: "b", Name: "bolt", Status: "retired"},
+		widget{ID: "c", Name: "nut", Status: "active"},
+	)
+	api := widgetApi(store)
+	api.Search = store.search
+	api.SearchFacets = func(filter widgetDto) map[string]map[string]int {
+		names := map[string]int{}
+		for _, w := range store.search(filter) {
+			names[w.Name]++
+		}
+		return map[string]map[string]int{"name": names}
+	}
+	app := serve(api)
+
+	tests := []struct {
+		method string
+		target string
+		body   string
+		ids    []string
+		names  map[string]int
+	}{
+		{method: fiber.MethodPost, target: "/widgets/filter", body: `{"status":"active"}`, ids: []string{"a", "c"}, names: map[string]int{"bolt": 1, "nut": 1}},
+		{method: fiber.MethodGet, target: "/widgets/filter?status=retired", ids: []string{"b"}, names: map[string]int{"bolt": 1}},
+		{method: fiber.MethodPost, target: "/widgets/filter", body: `{"status":"missing"}`, ids: []string{}, names: map[string]int{}},
+	}
+	for _, tt := range tests {
+		resp, body := call(t, app, tt.method, tt.target, tt.body)
+		expectStatus(t, resp, body, fiber.StatusOK)
+		var got struct {
+			Data   []widgetDto
+			Facets map[string]map[string]int
+		}
+		decodeBody(t, body, &got)
+		found := []string{}
+		for _, d := range got.Data {
+			found = append(found, d.ID)
+		}
+		if !slices.Equal(found, tt.ids) || !maps.Equal(got.Facets["name"], tt.names) {
+			t.Errorf("%s %s %s: %s, want %q with the name facets %v", tt.method, tt.target, tt.body, body, tt.ids, tt.names)
+		}
+	}
+}
+
+func TestContentType(t *testing.T) {
+	const vendor = "application/vnd.myapp+json"
+	store := newWidgetStore(numberedWidgets(3)...)
+	found := 0
+	api := cachedApi(store, &found)
+	api.ContentType = vendor
+	api.ExposeSchema = true
+	api.MaxBatchSize = 1
+	app := serve(api)
+
+	tests := []struct {
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{method: fiber.MethodGet, target: "/widgets/w01", status: fiber.StatusOK},
+		{method: fiber.MethodGet, target: "/widgets/", status: fiber.StatusOK},
+		{method: fiber.MethodGet, target: "/widgets/page/1", status: fiber.StatusOK},
+		{method: fiber.MethodGet, target: "/widgets/page/1", status: fiber.StatusOK}, // From the page cache
+		{method: fiber.MethodPost, target: "/widgets/batch", body: `[{"id":"x"},{"id":"y"}]`, status: fiber.StatusRequestEntityTooLarge},
+		{method: fiber.MethodGet, target: "/widgets/schema", status: fiber.StatusOK},
+		{method: fiber.MethodGet, target: "/widgets/_meta", status: fiber.StatusOK},
+	}
+	for _, tt := range tests {
+		resp, body := call(t, app, tt.method, tt.target, tt.body)
+		expectStatus(t, resp, body, tt.status)
+		if contentType := resp.Header.Get(fiber.HeaderContentType); contentType != vendor {
+			t.Errorf("%s %s: Content-Type %q, want %s", tt.method, tt.target, contentType, vendor)
+		}
+	}
+	if found != 1 {
+		t.Errorf("found the page %d times, want the second request served from the cache", found)
+	}
+}
+
+func TestCreateIfNoneMatch(t *testing.T) {
+	store := newWidgetStore(widget{ID: "a", Name: "alpha"})
+	api := widgetApi(store)
+	api.CreateWithID = func(id string, d widgetDto) (widget, error) {
+		d.ID = id
+		return store.create(d)
+	}
+	noneMatch := []string{fiber.HeaderIfNoneMatch, "*"}
+
+	// Without Exists the header is ignored
+	resp, body := call(t, serve(api), fiber.MethodPost, "/widgets/", `{"id":"b"}`, noneMatch...)
+	expectStatus(t, resp, body, fiber.StatusCreated)
+
+	api.Exists = func(d widgetDto) bool {
+		_, ok := store.find(d.ID)
+		return ok
+	}
+	app := serve(api)
+	resp, body = call(t, app, fiber.MethodPost, "/widgets/", `{"id":"a","name":"again"}`, noneMatch...)
+	expectStatus(t, resp, body, fiber.StatusPreconditionFailed)
+	if w := store.items["a"]; w.Name != "alpha" {
+		t.Errorf("a create only if new changed %+v", w)
+	}
+	resp, body = call(t, app, fiber.MethodPost, "/widgets/", `{"id":"c"}`, noneMatch...)
+	expectStatus(t, resp, body, fiber.StatusCreated)
+
+	// Without the header Exists is not consulted, the create fails as a conflict instead
+	resp, body = call(t, app, fiber.MethodPost, "/widgets/", `{"id":"a"}`)
+	expectStatus(t, resp, body, fiber.StatusConflict)
+}
+
+func TestCreateIfNoneMatchIdempotent(t *testing.T) {
+	store := newWidgetStore()
+	created := 0
+	api := widgetApi(store)
+	api.CreateWithID = func(id string, d widgetDto) (widget, error) {
+		created++
+		d.ID = id
+		return store.create(d)
+	}
+	api.Exists = func(d widgetDto) bool {
+		_, ok := store.find(d.ID)
+		return ok
+	}
+	api.IdempotencyTTL = time.Minute
+	app := serve(api)
+	create := func(key, body string) (*http.Response, string) {
+		return call(t, app, fiber.MethodPost, "/widgets/", body, fiber.HeaderIfNoneMatch, "*", HeaderIdempotencyKey, key)
+	}
+
+	resp, first := create("k1", `{"id":"a","name":"alpha"}`)
+	expectStatus(t, resp, first, fiber.StatusCreated)
+
+	// The retry is replayed before the Exists check, which its own create would now fail
+	resp, body := create("k1", `{"id":"a","name":"alpha"}`)
+	expectStatus(t, resp, body, fiber.StatusCreated)
+	if body != first || resp.Header.Get(HeaderIdempotentReplayed) != "true" || resp.Header.Get(fiber.HeaderLocation) != "/widgets/a" {
+		t.Errorf("retry %s %q, want the first response replayed", body, resp.Header)
+	}
+	if created != 1 {
+		t.Errorf("created %d times, want the retry not to create", created)
+	}
+
+	// Another key is a new create, so the precondition applies
+	resp, body = create("k2", `{"id":"a","name":"alpha"}`)
+	expectStatus(t, resp, body, fiber.StatusPreconditionFailed)
+	// A key reused for another create is rejected
+	resp, body = create("k1", `{"id":"b","name":"beta"}`)
+	expectStatus(t, resp, body, fiber.StatusUnprocessableEntity)
+	// A failed create is not kept, the key can be retried
+	resp, body = create("k2", `{"id":"b","name":"beta"}`)
+	expectStatus(t, resp, body, fiber.StatusCreated)
+	if created != 2 {
+		t.Errorf("created %d times, want 2", created)
+	}
+}
+
+func TestPageParametersAfterAuthorization(t *testing.T) {
+	store := newWidgetStore(numberedWidgets(3)...)
+	api := widgetApi(store)
+	api.Validator = func(c *fiber.Ctx, action Action, item ...widget) bool {
+		return c.Get(fiber.HeaderAuthorization) != ""
+	}
+	api.FindAllPage = func(page int64) Page[widget] {
+		return pageOf(store.findAll(), page, 2)
+	}
+	app := serve(api)
+	auth := []string{fiber.HeaderAuthorization, "Bearer token"}
+
+	// A denied caller learns nothing from the validation of the parameters
+	for _, target := range []string{"/widgets/page/1", "/widgets/page/x", "/widgets/page/0", "/widgets/page/1?size=x"} {
+		resp, body := call(t, app, fiber.MethodGet, target, "")
+		expectStatus(t, resp, body, fiber.StatusUnauthorized)
+	}
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/page/x", "", auth...)
+	expectStatus(t, resp, body, fiber.StatusBadRequest)
+
+	// FindAllPage sizes its own pages, so the size is not read
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/page/1?size=x", "", auth...)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	var page Page[widgetDto]
+	decodeBody(t, body, &page)
+	if len(page.Data) != 2 {
+		t.Errorf("page %s, want the 2 of FindAllPage", body)
+	}
+
+	api.FindAllPageSized = func(page int64, size int) Page[widget] {
+		return pageOf(store.findAll(), page, int64(size))
+	}
+	app = serve(api)
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/page/1?size=x", "", auth...)
+	expectStatus(t, resp, body, fiber.StatusBadRequest)
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/page/1?size=x", "")
+	expectStatus(t, resp, body, fiber.StatusUnauthorized)
+}
+
+func TestResponseInterceptor(t *testing.T) {
+	store := newWidgetStore(numberedWidgets(3)...)
+	var actions []Action
+	api := widgetApi(store)
+	api.ResponseInterceptor = func(c *fiber.Ctx, action Action, body any) any {
+		actions = append(actions, action)
+		return fiber.Map{"action": action.String(), "echo": c.Path(), "body": body}
+	}
+	app := serve(api)
+
+	tests := []struct {
+		target string
+		action Action
+		want   string
+	}{
+		{target: "/widgets/w01", action: ActionGetOne, want: `"body":{"id":"w01"`},
+		{target: "/widgets/", action: ActionGetAll, want: `"body":[{"id":"w01"`},
+		{target: "/widgets/page/1?size=2", action: ActionGetAll, want: `"currentPage":1`},
+	}
+	for _, tt := range tests {
+		actions = nil
+		resp, body := call(t, app, fiber.MethodGet, tt.target, "")
+		expectStatus(t, resp, body, fiber.StatusOK)
+		var got struct {
+			Action string
+			Echo   string
+		}
+		decodeBody(t, body, &got)
+		if got.Action != tt.action.String() || got.Echo != strings.Split(tt.target, "?")[0] || !strings.Contains(body, tt.want) {
+			t.Errorf("GET %s: %s, want the intercepted %s body", tt.target, body, tt.action)
+		}
+		if !slices.Equal(actions, []Action{tt.action}) {
+			t.Errorf("GET %s: intercepted %v, want once for %s", tt.target, actions, tt.action)
+		}
+	}
+}
+
+func TestCloneMounts(t *testing.T) {
+	store := newWidgetStore(numberedWidgets(3)...)
+	pages, scans := 0, 0
+	api := widgetApi(store)
+	api.FindAll = func() []widget {
+		scans++
+		return store.findAll()
+	}
+	api.FindAllPageSized = func(page int64, size int) Page[widget] {
+		pages++
+		return pageOf(store.findAll(), page, int64(size))
+	}
+	api.CacheTTL = time.Minute
+	api.Indexes = map[string]func(widget) string{
+		"status": func(w widget) string { return w.Status },
+	}
+	api.IdempotencyTTL = time.Minute
+	api.RateLimits = map[Action]RateLimit{ActionGetOne: {Rate: 0, Burst: 1}}
+	api.SensitiveFields = []string{"name"}
+	clone := api.Clone()
+	clone.SensitiveFields[0] = "status"
+	if api.SensitiveFields[0] != "name" {
+		t.Errorf("changing the clone changed the original SensitiveFields to %q", api.SensitiveFields)
+	}
+
+	app := fiber.New()
+	RegisterAPI(app.Group("/v1"), api)
+	RegisterAPI(app.Group("/admin"), clone)
+
+	// Each mount has its own rate limiters
+	resp, body := call(t, app, fiber.MethodGet, "/v1/widgets/w01", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	resp, body = call(t, app, fiber.MethodGet, "/v1/widgets/w01", "")
+	expectStatus(t, resp, body, fiber.StatusTooManyRequests)
+	resp, body = call(t, app, fiber.MethodGet, "/admin/widgets/w01", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+
+	// Each mount caches its own pages and builds its own indexes
+	for _, prefix := range []string{"/v1", "/admin", "/v1", "/admin"} {
+		resp, body = call(t, app, fiber.MethodGet, prefix+"/widgets/page/1", "")
+		expectStatus(t, resp, body, fiber.StatusOK)
+		resp, body = call(t, app, fiber.MethodGet, prefix+"/widgets/by/status/active", "")
+		expectStatus(t, resp, body, fiber.StatusOK)
+	}
+	if pages != 2 || scans != 2 {
+		t.Errorf("found %d pages and built %d indexes, want one of each per mount", pages, scans)
+	}
+
+	// Each mount keeps its own idempotent creates
+	key := []string{HeaderIdempotencyKey, "k1"}
+	resp, body = call(t, app, fiber.MethodPost, "/v1/widgets/", `{"id":"new"}`, key...)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	resp, body = call(t, app, fiber.MethodPost, "/v1/widgets/", `{"id":"new"}`, key...)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if resp.Header.Get(HeaderIdempotentReplayed) != "true" {
+		t.Error("the retry on the same mount was not replayed")
+	}
+	resp, body = call(t, app, fiber.MethodPost, "/admin/widgets/", `{"id":"new"}`, key...)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if resp.Header.Get(HeaderIdempotentReplayed) != "" {
+		t.Error("the key of one mount was replayed on the other")
+	}
+}
+
+func TestEvents(t *testing.T) {
+	api := widgetApi(newWidgetStore())
+	api.Subscribe = func(ctx context.Context) <-chan ChangeEvent {
+		events := make(chan ChangeEvent, 2)
+		events <- ChangeEvent{Action: ActionCreate, ID: "a", Data: widgetDto{ID: "a", Name: "alpha"}}
+		events <- ChangeEvent{Action: ActionDelete, ID: "b"}
+		close(events)
+		return events
+	}
+
+	resp, body := call(t, serve(api), fiber.MethodGet, "/widgets/events", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if contentType := resp.Header.Get(fiber.HeaderContentType); contentType != "text/event-stream" {
+		t.Errorf("Content-Type %q, want text/event-stream", contentType)
+	}
+	want := "event: create\nid: a\ndata: {\"id\":\"a\",\"name\":\"alpha\",\"status\":\"\"}\n\n" +
+		"event: delete\nid: b\ndata: null\n\n"
+	if body != want {
+		t.Errorf("events %q, want %q", body, want)
+	}
+}
+
+func TestEventsDisconnect(t *testing.T) {
+	var mu sync.Mutex
+	subscribers := map[chan ChangeEvent]bool{}
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(subscribers)
+	}
+	api := widgetApi(newWidgetStore())
+	api.Subscribe = func(ctx context.Context) <-chan ChangeEvent {
+		events := make(chan ChangeEvent)
+		mu.Lock()
+		subscribers[events] = true
+		mu.Unlock()
+		go func() {
+			<-ctx.Done()
+			mu.Lock()
+			delete(subscribers, events)
+			mu.Unlock()
+		}()
+		return events
+	}
+	publish := func(event ChangeEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		for events := range subscribers {
+			select {
+			case events <- event:
+			default:
+			}
+		}
+	}
+	app := serve(api)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	defer app.Shutdown()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(conn, "GET /widgets/events HTTP/1.1\r\nHost: test\r\n\r\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for count() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("the stream did not subscribe")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Once the client has gone, the next events fail to flush and end the subscription
+	conn.Close()
+	for count() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the subscriber was not removed after the client disconnected")
+		}
+		publish(ChangeEvent{Action: ActionMutate, ID: "a"})
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	store := newWidgetStore(widget{ID: "a", Name: "alpha"})
+	api := widgetApi(store)
+	api.ApplyPatch = func(w widget) (widget, error) { return w, nil }
+	api.CreateMultipart = func(c *fiber.Ctx, files map[string][]*multipart.FileHeader, fields widgetDto) (widget, error) {
+		return store.create(fields)
+	}
+	api.MaxBodyBytes = 64
+	app := serve(api)
+	large := `{"id":"b","name":"` + strings.Repeat("x", 64) + `"}`
+
+	tests := []struct {
+		method string
+		target string
+		header []string
+	}{
+		{method: fiber.MethodPost, target: "/widgets/"},
+		{method: fiber.MethodPut, target: "/widgets/a"},
+		{method: fiber.MethodPatch, target: "/widgets/a", header: []string{fiber.HeaderContentType, MIMEApplicationJSONPatch}},
+		{method: fiber.MethodPost, target: "/widgets/batch"},
+	}
+	for _, tt := range tests {
+		resp, body := call(t, app, tt.method, tt.target, large, tt.header...)
+		expectStatus(t, resp, body, fiber.StatusRequestEntityTooLarge)
+	}
+
+	// An upload
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	writer.WriteField("id", "c")
+	file, _ := writer.CreateFormFile("file", "large.txt")
+	file.Write([]byte(strings.Repeat("x", 64)))
+	writer.Close()
+	resp, body := call(t, app, fiber.MethodPost, "/widgets/", form.String(), fiber.HeaderContentType, writer.FormDataContentType())
+	expectStatus(t, resp, body, fiber.StatusRequestEntityTooLarge)
+
+	// A compressed body small as sent but too large once decoded
+	var compressed bytes.Buffer
+	zipper := gzip.NewWriter(&compressed)
+	zipper.Write([]byte(`{"id":"b","name":"` + strings.Repeat("x", 4096) + `"}`))
+	zipper.Close()
+	if compressed.Len() > api.MaxBodyBytes {
+		t.Fatalf("compressed to %d bytes, want it under the limit", compressed.Len())
+	}
+	resp, body = call(t, app, fiber.MethodPost, "/widgets/", compressed.String(), fiber.HeaderContentEncoding, "gzip")
+	expectStatus(t, resp, body, fiber.StatusRequestEntityTooLarge)
+	if _, ok := store.items["b"]; ok || len(store.items) != 1 {
+		t.Errorf("oversized bodies created %+v", store.items)
+	}
+
+	resp, body = call(t, app, fiber.MethodPost, "/widgets/", `{"id":"b","name":"small"}`)
+	expectStatus(t, resp, body, fiber.StatusOK)
+}
+
+func TestRecoverPanics(t *testing.T) {
+	store := newWidgetStore(widget{ID: "a", Name: "boom"})
+	var logged syncBuffer
+	api := widgetApi(store)
+	api.Dto = func(w widget) widgetDto {
+		if w.Name == "boom" {
+			panic("broken Dto")
+		}
+		return toWidgetDto(w)
+	}
+	api.CreateWithID = func(id string, d widgetDto) (widget, error) {
+		d.ID = id
+		return store.create(d)
+	}
+	api.Version = func(w widget) string { return "v1" }
+	api.LastModified = func(w widget) time.Time { return time.Now() }
+	api.RecoverPanics = true
+	api.APIVersion = "v2"
+	api.CORS = &CORSConfig{}
+	api.Logger = slog.New(slog.NewTextHandler(&logged, nil))
+	app := serve(api)
+	origin := []string{fiber.HeaderOrigin, "https://a.example"}
+
+	tests := []struct {
+		method string
+		target string
+		body   string
+	}{
+		// The ETag and Last-Modified are set before the Dto panics
+		{method: fiber.MethodGet, target: "/v2/widgets/a"},
+		// The Location is set before the Dto panics
+		{method: fiber.MethodPost, target: "/v2/widgets/", body: `{"id":"b","name":"boom"}`},
+	}
+	for _, tt := range tests {
+		resp, body := call(t, app, tt.method, tt.target, tt.body, origin...)
+		expectStatus(t, resp, body, fiber.StatusInternalServerError)
+		if body != `{"error":"Internal Server Error"}` {
+			t.Errorf("%s %s: body %s, want the json 500", tt.method, tt.target, body)
+		}
+		for _, name := range []string{fiber.HeaderETag, fiber.HeaderLastModified, fiber.HeaderLocation} {
+			if value := resp.Header.Get(name); value != "" {
+				t.Errorf("%s %s: the 500 kept the %s %q of the panicking handler", tt.method, tt.target, name, value)
+			}
+		}
+		if resp.Header.Get(HeaderAPIVersion) != "v2" || resp.Header.Get(fiber.HeaderAccessControlAllowOrigin) != "*" {
+			t.Errorf("%s %s: the 500 lost the version or CORS headers %q", tt.method, tt.target, resp.Header)
+		}
+	}
+	if !strings.Contains(logged.String(), "broken Dto") {
+		t.Errorf("the panic was not logged: %s", logged.String())
+	}
+}
+
+func TestOwnershipCheck(t *testing.T) {
+	store := newWidgetStore(widget{ID: "a", Name: "alpha", Owner: "ann"}, widget{ID: "b", Name: "beta", Owner: "bob"})
+	checked := 0
+	api := widgetApi(store)
+	api.SubEntities = []SubEntity[widget, widgetDto]{{SubPath: "parts", Get: widgetParts}}
+	api.Validator = func(c *fiber.Ctx, action Action, item ...widget) bool {
+		return c.Get("X-User") != ""
+	}
+	api.OwnershipCheck = func(c *fiber.Ctx, w widget) bool {
+		checked++
+		return w.Owner == c.Get("X-User")
+	}
+	app := serve(api)
+
+	requests := []struct {
+		method string
+		target string
+		body   string
+	}{
+		{method: fiber.MethodGet, target: "/widgets/a"},
+		{method: fiber.MethodGet, target: "/widgets/a/parts"},
+		{method: fiber.MethodPut, target: "/widgets/a", body: `{"name":"taken"}`},
+		{method: fiber.MethodDelete, target: "/widgets/a"},
+	}
+	for _, tt := range requests {
+		// The Validator rejects anonymous callers before ownership is checked
+		checked = 0
+		resp, body := call(t, app, tt.method, tt.target, tt.body)
+		expectStatus(t, resp, body, fiber.StatusUnauthorized)
+		if checked != 0 {
+			t.Errorf("%s %s: ownership checked for a caller the Validator rejected", tt.method, tt.target)
+		}
+		resp, body = call(t, app, tt.method, tt.target, tt.body, "X-User", "bob")
+		expectStatus(t, resp, body, fiber.StatusForbidden)
+	}
+	if w := store.items["a"]; w.Name != "alpha" {
+		t.Errorf("another caller changed %+v", w)
+	}
+
+	// The collection is not an item level action
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/", "", "X-User", "bob")
+	expectStatus(t, resp, body, fiber.StatusOK)
+
+	for _, tt := range requests {
+		resp, body := call(t, app, tt.method, tt.target, tt.body, "X-User", "ann")
+		expectStatus(t, resp, body, fiber.StatusOK)
+	}
+	if _, ok := store.items["a"]; ok {
+		t.Error("the owner could not delete a")
+	}
+}
+
+func TestNotFoundPrecedence(t *testing.T) {
+	tests := []struct {
+		name          string
+		precedence    NotFoundPrecedence
+		disableLeak   bool
+		deniedPresent int
+		deniedAbsent  int
+	}{
+		{name: "leak", precedence: PrecedenceLeak, deniedPresent: fiber.StatusUnauthorized, deniedAbsent: fiber.StatusUnauthorized},
+		{name: "forbid first", precedence: PrecedenceAlwaysForbidFirst, deniedPresent: fiber.StatusForbidden, deniedAbsent: fiber.StatusForbidden},
+		{name: "not found first", precedence: PrecedenceAlwaysNotFoundFirst, deniedPresent: fiber.StatusUnauthorized, deniedAbsent: fiber.StatusNotFound},
+		{name: "leak protection disabled", precedence: PrecedenceLeak, disableLeak: true, deniedPresent: fiber.StatusUnauthorized, deniedAbsent: fiber.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newWidgetStore(widget{ID: "a"})
+			api := widgetApi(store)
+			api.Validator = func(c *fiber.Ctx, action Action, item ...widget) bool {
+				return c.Get("X-User") != ""
+			}
+			api.NotFoundPrecedence = tt.precedence
+			api.DisableLeakProtection = tt.disableLeak
+			app := serve(api)
+
+			for _, method := range []string{fiber.MethodGet, fiber.MethodDelete} {
+				resp, body := call(t, app, method, "/widgets/a", "")
+				expectStatus(t, resp, body, tt.deniedPresent)
+				resp, body = call(t, app, method, "/widgets/missing", "")
+				expectStatus(t, resp, body, tt.deniedAbsent)
+				// An allowed caller always learns an item is missing
+				resp, body = call(t, app, method, "/widgets/missing", "", "X-User", "ann")
+				expectStatus(t, resp, body, fiber.StatusNotFound)
+			}
+			if _, ok := store.items["a"]; !ok {
+				t.Error("a denied caller deleted a")
+			}
+		})
+	}
+}
+
+func TestGetAllWindow(t *testing.T) {
+	app := serve(widgetApi(newWidgetStore(numberedWidgets(3)...)))
+
+	tests := []struct {
+		query  string
+		ids    []string
+		offset string
+		limit  string
+	}{
+		{query: "offset=1&limit=1", ids: []string{"w02"}, offset: "1", limit: "1"},
+		{query: "offset=1", ids: []string{"w02", "w03"}, offset: "1", limit: "2"},
+		{query: "limit=2", ids: []string{"w01", "w02"}, offset: "0", limit: "2"},
+		{query: "offset=5", ids: []string{}, offset: "5", limit: "0"},
+		{query: "offset=2&limit=0", ids: []string{}, offset: "2", limit: "0"},
+		// The window is clamped before it is added up, so the largest limit does not overflow
+		{query: "offset=1&limit=" + strconv.Itoa(math.MaxInt), ids: []string{"w02", "w03"}, offset: "1", limit: strconv.Itoa(math.MaxInt)},
+		{query: "offset=" + strconv.Itoa(math.MaxInt) + "&limit=" + strconv.Itoa(math.MaxInt), ids: []string{}, offset: strconv.Itoa(math.MaxInt), limit: strconv.Itoa(math.MaxInt)},
+	}
+	for _, tt := range tests {
+		resp, body := call(t, app, fiber.MethodGet, "/widgets/?"+tt.query, "")
+		expectStatus(t, resp, body, fiber.StatusOK)
+		if got := ids(t, body); !slices.Equal(got, tt.ids) {
+			t.Errorf("?%s: %q, want %q", tt.query, got, tt.ids)
+		}
+		if total, offset, limit := resp.Header.Get(HeaderTotalCount), resp.Header.Get(HeaderOffset), resp.Header.Get(HeaderLimit); total != "3" || offset != tt.offset || limit != tt.limit {
+			t.Errorf("?%s: total %s, offset %s, limit %s, want 3, %s, %s", tt.query, total, offset, limit, tt.offset, tt.limit)
+		}
+	}
+
+	// Without a window the bare collection is sent
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if got := ids(t, body); len(got) != 3 || resp.Header.Get(HeaderTotalCount) != "" {
+		t.Errorf("unlimited %q with total %q, want all 3 without the window headers", got, resp.Header.Get(HeaderTotalCount))
+	}
+
+	for _, query := range []string{"offset=-1", "limit=-1", "offset=x", "limit=" + strconv.Itoa(math.MaxInt) + "0"} {
+		resp, body := call(t, app, fiber.MethodGet, "/widgets/?"+query, "")
+		expectStatus(t, resp, body, fiber.StatusBadRequest)
+	}
+}
@@ -0,0 +1,176 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"log/slog"
+	"strings"
+)
+
+// batchCreate creates each of the Dtos in the array body, returning their Dtos with 201 (Created).
+// The creation stops at the first error, the items created before it remain.
+// With EnqueueBatch the batch is enqueued instead and answered with 202 (Accepted) and the job id.
+func batchCreate[T any, D any](api Api[T, D]) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+
+		var batch []D
+		if done, err := api.parseBody(c, &batch); done {
+			return err
+		}
+		if done, err := api.batchTooLarge(c, len(batch)); done {
+			return err
+		}
+
+		if allowed, err := api.authorize(c, ActionCreate); !allowed {
+			return api.sendDenied(c, fiber.StatusUnauthorized, err)
+		}
+
+		if api.EnqueueBatch != nil {
+			return api.enqueueBatch(c, ActionCreate, batch)
+		}
+
+		ctx, cancel := api.context(c)
+		defer cancel()
+		created := make([]D, 0, len(batch))
+		for _, edit := range batch {
+			item, err := api.create(ctx, edit)
+			if timedOut(ctx) {
+				return api.sendError(c, fiber.StatusGatewayTimeout, nil)
+			}
+			if err != nil {
+				api.logf(slog.LevelError, "Error creating item: %v, %v\n", redact(item, api.SensitiveFields), err)
+				return api.sendDataError(c, err)
+			}
+			dto, err := api.dto(item)
+			if err != nil {
+				return api.sendDataError(c, err)
+			}
+			created = append(created, dto)
+		}
+		c.Status(fiber.StatusCreated)
+		return api.send(c, ActionCreate, created)
+	}
+}
+
+// batchDelete deletes the items matching each of the Dtos in the array body, found with FindByFilter,
+// and reports how many were deleted.  Missing items are skipped, nothing is deleted if any item fails the checks
+// of a delete, the Validator or the OwnershipCheck.
+// With EnqueueBatch the batch is enqueued instead and answered with 202 (Accepted) and the job id.
+func batchDelete[T any, D any](api Api[T, D]) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+
+		var batch []D
+		if done, err := api.parseBody(c, &batch); done {
+			return err
+		}
+		if done, err := api.batchTooLarge(c, len(batch)); done {
+			return err
+		}
+
+		if api.EnqueueBatch != nil {
+			if allowed, err := api.authorize(c, ActionDelete); !allowed {
+				return api.sendDenied(c, fiber.StatusUnauthorized, err)
+			}
+			return api.enqueueBatch(c, ActionDelete, batch)
+		}
+
+		// Find and check all the items before deleting any
+		items := make([]T, 0, len(batch))
+		for _, filter := range batch {
+			item, ok := api.FindByFilter(filter)
+			status, err := api.checkItem(c, ActionDelete, item, ok)
+			if status == fiber.StatusNotFound {
+				continue
+			}
+			if status != 0 {
+				return api.sendError(c, status, err)
+			}
+			items = append(items, item)
+		}
+
+		ctx, cancel := api.context(c)
+		defer cancel()
+		deleted := 0
+		for _, item := range items {
+			_, err := api.delete(ctx, item)
+			if timedOut(ctx) {
+				return api.sendError(c, fiber.StatusGatewayTimeout, nil)
+			}
+			if err != nil {
+				api.logf(slog.LevelError, "Error deleting item: %v\n", err)
+				return api.sendDataError(c, err)
+			}
+			deleted++
+		}
+		return api.send(c, ActionDelete, fiber.Map{"deleted": deleted})
+	}
+}
+
+// batchTooLarge answers a batch of n items beyond the MaxBatchSize with 413 (Payload Too Large),
+// stating the limit and the count received, and reports if it did
+func (api Api[T, D]) batchTooLarge(c *fiber.Ctx, n int) (bool, error) {
+	if api.MaxBatchSize <= 0 || n <= api.MaxBatchSize {
+		return false, nil
+	}
+	err := fmt.Errorf("batch of %d items exceeds the limit of %d", n, api.MaxBatchSize)
+	members := fiber.Map{"limit": api.MaxBatchSize, "count": n}
+	if !api.EnableProblemJSON {
+		// The problem details carry the message as their detail
+		members["error"] = err.Error()
+	}
+	return true, api.sendErrorWith(c, fiber.StatusRequestEntityTooLarge, err, members)
+}
+
+// enqueueBatch hands the batch for action to EnqueueBatch and answers 202 (Accepted) with the job id,
+// locating the job under /jobs when JobStatus is set
+func (api Api[T, D]) enqueueBatch(c *fiber.Ctx, action Action, batch []D) error {
+	jobID, err := api.EnqueueBatch(action, batch)
+	if err != nil {
+		api.logf(slog.LevelError, "Error enqueuing %s batch: %v\n", action, err)
+		return api.sendDataError(c, err)
+	}
+	if api.JobStatus != nil {
+		c.Location(strings.TrimSuffix(c.Path(), "/batch") + "/jobs/" + jobID)
+	}
+	c.Status(fiber.StatusAccepted)
+	return api.send(c, action, fiber.Map{"jobId": jobID})
+}
+
+// jobStatus returns the status of the job :jobId enqueued by EnqueueBatch
+// 404 if JobStatus does not know the job
+func jobStatus[T any, D any](api Api[T, D]) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Perms check
+		if allowed, err := api.authorize(c, ActionGetAll); !allowed {
+			return api.sendDenied(c, fiber.StatusUnauthorized, err)
+		}
+
+		status, ok := api.JobStatus(c.Params("jobId"))
+		if !ok {
+			return api.sendError(c, fiber.StatusNotFound, nil)
+		}
+		return api.send(c, ActionGetAll, status)
+	}
+}
@@ -0,0 +1,111 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"testing"
+)
+
+func TestMaxBatchSize(t *testing.T) {
+	store := newWidgetStore(numberedWidgets(3)...)
+	api := widgetApi(store)
+	api.MaxBatchSize = 2
+	app := serve(api)
+
+	tests := []struct {
+		method string
+		target string
+		body   string
+	}{
+		{method: fiber.MethodPost, target: "/widgets/batch", body: `[{"id":"x"},{"id":"y"},{"id":"z"}]`},
+		{method: fiber.MethodPost, target: "/widgets/mget", body: `{"ids":["w01","w02","w03"]}`},
+	}
+	for _, tt := range tests {
+		resp, body := call(t, app, tt.method, tt.target, tt.body)
+		expectStatus(t, resp, body, fiber.StatusRequestEntityTooLarge)
+		var got struct{ Limit, Count int }
+		decodeBody(t, body, &got)
+		if got.Limit != 2 || got.Count != 3 {
+			t.Errorf("%s %s: %s, want the limit 2 and the count 3", tt.method, tt.target, body)
+		}
+	}
+	if len(store.items) != 3 {
+		t.Errorf("a rejected batch created items, %d stored", len(store.items))
+	}
+
+	resp, body := call(t, app, fiber.MethodPost, "/widgets/batch", `[{"id":"x"},{"id":"y"}]`)
+	expectStatus(t, resp, body, fiber.StatusCreated)
+}
+
+func TestMaxBatchSizeProblem(t *testing.T) {
+	api := widgetApi(newWidgetStore())
+	api.MaxBatchSize = 1
+	api.EnableProblemJSON = true
+	app := serve(api)
+
+	resp, body := call(t, app, fiber.MethodPost, "/widgets/batch", `[{"id":"x"},{"id":"y"}]`)
+	expectStatus(t, resp, body, fiber.StatusRequestEntityTooLarge)
+	if contentType := resp.Header.Get(fiber.HeaderContentType); contentType != MIMEApplicationProblemJSON {
+		t.Errorf("Content-Type %q, want %s", contentType, MIMEApplicationProblemJSON)
+	}
+	var got struct {
+		Status       int
+		Detail       string
+		Limit, Count int
+	}
+	decodeBody(t, body, &got)
+	if got.Status != fiber.StatusRequestEntityTooLarge || got.Detail == "" || got.Limit != 1 || got.Count != 2 {
+		t.Errorf("problem %s, want the limit and count as extension members", body)
+	}
+}
+
+func TestBatchDeleteOwnership(t *testing.T) {
+	store := newWidgetStore(
+		widget{ID: "a", Owner: "ann"},
+		widget{ID: "b", Owner: "ann"},
+		widget{ID: "c", Owner: "bob"},
+	)
+	api := widgetApi(store)
+	api.FindByFilter = func(filter widgetDto) (widget, bool) {
+		return store.find(filter.ID)
+	}
+	api.OwnershipCheck = func(c *fiber.Ctx, w widget) bool {
+		return w.Owner == c.Get("X-User")
+	}
+	app := serve(api)
+	ann := []string{"X-User", "ann"}
+
+	// One item of the batch belongs to bob, so none is deleted
+	resp, body := call(t, app, fiber.MethodDelete, "/widgets/batch", `[{"id":"a"},{"id":"c"},{"id":"b"}]`, ann...)
+	expectStatus(t, resp, body, fiber.StatusForbidden)
+	if len(store.items) != 3 {
+		t.Errorf("a rejected batch deleted items, %d left", len(store.items))
+	}
+
+	resp, body = call(t, app, fiber.MethodDelete, "/widgets/batch", `[{"id":"a"},{"id":"missing"},{"id":"b"}]`, ann...)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if body != `{"deleted":2}` || len(store.items) != 1 {
+		t.Errorf("deleted %s, leaving %+v", body, store.items)
+	}
+}
@@ -0,0 +1,159 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// pageCache holds the responses of GET /page/:id for the CacheTTL, by page, query, and the locale and page size
+// where they vary by caller
+type pageCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedPage
+}
+
+// cachedPage is a response of GET /page/:id as sent
+type cachedPage struct {
+	body        []byte
+	contentType string
+	link        string
+	expires     time.Time
+}
+
+// get returns the unexpired page cached under key
+func (p *pageCache) get(key string) (cachedPage, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	page, ok := p.entries[key]
+	if !ok || time.Now().After(page.expires) {
+		delete(p.entries, key)
+		return cachedPage{}, false
+	}
+	return page, true
+}
+
+// put caches page under key
+func (p *pageCache) put(key string, page cachedPage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.entries == nil {
+		p.entries = map[string]cachedPage{}
+	}
+	p.entries[key] = page
+}
+
+// clear drops every cached page
+func (p *pageCache) clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.entries = nil
+}
+
+// isWrite reports if action changes the items of the resource
+func (action Action) isWrite() bool {
+	switch action {
+	case ActionMutate, ActionCreate, ActionDelete, ActionDeleteAll:
+		return true
+	}
+	return false
+}
+
+// cacheable reports if the pages of GET /page/:id are cached.  The per request hooks, Enrich and
+// ResponseInterceptor, could make the page cached for one caller wrong for the next.
+func (api Api[T, D]) cacheable() bool {
+	return api.CacheTTL > 0 && api.Enrich == nil && api.ResponseInterceptor == nil
+}
+
+// cachePages serves GET /page/:id from the page cache, running handler on a miss and caching what it sends.
+// Concurrent misses of the same page are coalesced, the first runs handler and the others are sent its response.
+func (api Api[T, D]) cachePages(handler fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Params("id") + "?" + string(c.Request().URI().QueryString())
+		if api.DtoLocalized != nil {
+			// Localized pages differ by locale
+			key += "#" + api.locale(c)
+		}
+		if api.MaxPageSizeFor != nil {
+			// Callers with different caps get pages of different sizes
+			size, _ := api.pageSize(c)
+			key += "#" + strconv.Itoa(size)
+		}
+		if page, ok := api.mount.pages.get(key); ok {
+			return api.sendCachedPage(c, page)
+		}
+		led := false
+		_, err, _ := api.mount.flight.Do("page:"+key, func() (any, error) {
+			led = true
+			if err := handler(c); err != nil {
+				return nil, err
+			}
+			if c.Response().StatusCode() == fiber.StatusOK {
+				api.mount.pages.put(key, cachedPage{
+					body:        append([]byte(nil), c.Response().Body()...),
+					contentType: string(c.Response().Header.ContentType()),
+					link:        string(c.Response().Header.Peek(fiber.HeaderLink)),
+					expires:     time.Now().Add(api.CacheTTL),
+				})
+			}
+			return nil, nil
+		})
+		if led {
+			return err
+		}
+		if page, ok := api.mount.pages.get(key); ok {
+			return api.sendCachedPage(c, page)
+		}
+		// The shared request was not cached, find the page afresh
+		return handler(c)
+	}
+}
+
+// sendCachedPage sends the cached response page, if the caller may see it
+func (api Api[T, D]) sendCachedPage(c *fiber.Ctx, page cachedPage) error {
+	if allowed, err := api.authorize(c, ActionGetAll); !allowed {
+		return api.sendDenied(c, fiber.StatusUnauthorized, err)
+	}
+	api.warn(c, WarningStale)
+	if page.link != "" {
+		c.Set(fiber.HeaderLink, page.link)
+	}
+	c.Set(fiber.HeaderContentType, page.contentType)
+	return c.Send(page.body)
+}
+
+// invalidate drops the cached pages and built Indexes once a write handled by handler completes,
+// as paging shifts with any write
+func (api Api[T, D]) invalidate(handler fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := handler(c)
+		if c.Response().StatusCode() < fiber.StatusBadRequest {
+			api.mount.pages.clear()
+			api.mount.indexes.clear()
+		}
+		return err
+	}
+}
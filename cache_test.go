@@ -0,0 +1,90 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cachedApi is a widget Api caching its pages, counting the pages found
+func cachedApi(store *widgetStore, found *int) Api[widget, widgetDto] {
+	api := widgetApi(store)
+	api.FindAllPageSized = func(page int64, size int) Page[widget] {
+		*found++
+		return pageOf(store.findAll(), page, int64(size))
+	}
+	api.CacheTTL = time.Minute
+	return api
+}
+
+func TestCachePages(t *testing.T) {
+	store := newWidgetStore(numberedWidgets(3)...)
+	found := 0
+	app := serve(cachedApi(store, &found))
+
+	resp, first := call(t, app, fiber.MethodGet, "/widgets/page/1", "")
+	expectStatus(t, resp, first, fiber.StatusOK)
+	resp, second := call(t, app, fiber.MethodGet, "/widgets/page/1", "")
+	expectStatus(t, resp, second, fiber.StatusOK)
+	if found != 1 || first != second {
+		t.Errorf("found the page %d times, want the second request served from the cache", found)
+	}
+	if resp.Header.Get(fiber.HeaderLink) == "" {
+		t.Error("the cached page lost its Link header")
+	}
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/page/2?size=1", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if found != 2 {
+		t.Errorf("found the pages %d times, want another page found", found)
+	}
+
+	// A write shifts the pages, so it drops them all
+	resp, body = call(t, app, fiber.MethodPost, "/widgets/", `{"id":"w00","name":"first"}`)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/page/1", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if found != 3 || !strings.Contains(body, `"w00"`) {
+		t.Errorf("found the pages %d times, want the page found again after the write: %s", found, body)
+	}
+}
+
+func TestCachePagesPerCaller(t *testing.T) {
+	store := newWidgetStore(numberedWidgets(3)...)
+	found := 0
+	api := cachedApi(store, &found)
+	api.Enrich = func(c *fiber.Ctx, w widget) widget {
+		w.Name = c.Get("X-User") + "'s " + w.Name
+		return w
+	}
+	app := serve(api)
+
+	call(t, app, fiber.MethodGet, "/widgets/page/1", "", "X-User", "ann")
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/page/1", "", "X-User", "bob")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if found != 2 || strings.Contains(body, "ann") {
+		t.Errorf("found the page %d times, bob got %s, want pages enriched per caller not cached", found, body)
+	}
+}
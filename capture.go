@@ -0,0 +1,83 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"net/http"
+)
+
+// RequestSnapshot is a copy of a failed request handed to CaptureFailed, e.g. to persist it for replay.
+// The values of the credentialHeaders, and of the headers and json body fields named in SensitiveFields,
+// are replaced by "***".
+type RequestSnapshot struct {
+	Method string
+	Path   string // The original url of the request, including its query string
+	Header http.Header
+	Body   []byte
+	Status int // The 5xx status the request failed with
+}
+
+// credentialHeaders are the request headers carrying credentials, always redacted from a RequestSnapshot
+var credentialHeaders = []string{fiber.HeaderAuthorization, fiber.HeaderProxyAuthorization, fiber.HeaderCookie, "X-Api-Key"}
+
+// captureFailed hands a snapshot of the requests handler fails with a 5xx status to CaptureFailed
+func (api Api[T, D]) captureFailed(handler fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := handler(c)
+		if status := responseStatus(c, err); status >= fiber.StatusInternalServerError {
+			api.CaptureFailed(api.snapshot(c, status))
+		}
+		return err
+	}
+}
+
+// snapshot copies the request of c, which outlives the request, redacting its credentialHeaders and SensitiveFields
+func (api Api[T, D]) snapshot(c *fiber.Ctx, status int) RequestSnapshot {
+	header := http.Header{}
+	c.Request().Header.VisitAll(func(key, value []byte) {
+		name := string(key)
+		if isSensitive(name, credentialHeaders) || isSensitive(name, api.SensitiveFields) {
+			header.Add(name, redactedValue)
+			return
+		}
+		header.Add(name, string(value))
+	})
+	body := append([]byte(nil), c.Body()...)
+	if len(api.SensitiveFields) > 0 {
+		var doc any
+		if json.Unmarshal(body, &doc) == nil {
+			redactValue(doc, api.SensitiveFields)
+			body, _ = json.Marshal(doc)
+		}
+	}
+	return RequestSnapshot{
+		Method: utils.CopyString(c.Method()),
+		Path:   utils.CopyString(c.OriginalURL()),
+		Header: header,
+		Body:   body,
+		Status: status,
+	}
+}
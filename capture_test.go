@@ -0,0 +1,77 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+	"testing"
+)
+
+func TestCaptureFailed(t *testing.T) {
+	var captured []RequestSnapshot
+	api := widgetApi(newWidgetStore(widget{ID: "a"}))
+	api.Create = func(d widgetDto) (widget, error) {
+		return widget{}, errors.New("database unavailable")
+	}
+	api.SensitiveFields = []string{"name", "X-Secret"}
+	api.CaptureFailed = func(req RequestSnapshot) {
+		captured = append(captured, req)
+	}
+	app := serve(api)
+
+	resp, body := call(t, app, fiber.MethodPost, "/widgets/?source=test", `{"id":"b","name":"secret","status":"new"}`,
+		fiber.HeaderAuthorization, "Bearer token",
+		fiber.HeaderCookie, "session=1",
+		"X-Secret", "hidden",
+		"X-Request-Id", "r1")
+	expectStatus(t, resp, body, fiber.StatusInternalServerError)
+	if len(captured) != 1 {
+		t.Fatalf("captured %d requests, want 1", len(captured))
+	}
+	got := captured[0]
+	if got.Method != fiber.MethodPost || got.Path != "/widgets/?source=test" || got.Status != fiber.StatusInternalServerError {
+		t.Errorf("captured %s %s %d", got.Method, got.Path, got.Status)
+	}
+	for _, name := range []string{fiber.HeaderAuthorization, fiber.HeaderCookie, "X-Secret"} {
+		if value := got.Header.Get(name); value != redactedValue {
+			t.Errorf("captured %s %q, want it redacted", name, value)
+		}
+	}
+	if value := got.Header.Get("X-Request-Id"); value != "r1" {
+		t.Errorf("captured X-Request-Id %q, want r1", value)
+	}
+	if body := string(got.Body); strings.Contains(body, "secret") || !strings.Contains(body, `"name":"***"`) || !strings.Contains(body, `"status":"new"`) {
+		t.Errorf("captured body %s, want the name redacted", body)
+	}
+
+	// Successes and client errors are not captured
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/a", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/missing", "")
+	expectStatus(t, resp, body, fiber.StatusNotFound)
+	if len(captured) != 1 {
+		t.Errorf("captured %d requests, want only the failure", len(captured))
+	}
+}
@@ -0,0 +1,92 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CORSConfig configures the CORS headers of a single Api resource.
+// The allowed methods are those the Api registers, so a preflight advertises exactly what the resource supports.
+type CORSConfig struct {
+	AllowOrigins     []string // Origins allowed to call the resource, empty or "*" allows any origin
+	AllowHeaders     []string // Request headers allowed on calls
+	ExposeHeaders    []string // Response headers exposed to the browser
+	AllowCredentials bool     // Allow credentials, only with explicit AllowOrigins: registering with any origin panics
+	MaxAge           int      // Seconds a preflight may be cached for, 0 for the browser default
+}
+
+// cors returns the middleware adding the CORS headers and answering preflight requests for a resource
+// exposing routes.  It panics if credentials are allowed from any origin, which would let any site call with them
+func (config *CORSConfig) cors(routes []route) fiber.Handler {
+	methods := []string{fiber.MethodOptions}
+	for _, r := range routes {
+		if !slices.Contains(methods, r.method) {
+			methods = append(methods, r.method)
+		}
+	}
+	allowMethods := strings.Join(methods, ", ")
+	anyOrigin := len(config.AllowOrigins) == 0 || slices.Contains(config.AllowOrigins, "*")
+	if anyOrigin && config.AllowCredentials {
+		panic("CORS AllowCredentials requires explicit AllowOrigins, not any origin")
+	}
+
+	return func(c *fiber.Ctx) error {
+		origin := c.Get(fiber.HeaderOrigin)
+		c.Vary(fiber.HeaderOrigin)
+		if origin == "" || !(anyOrigin || slices.Contains(config.AllowOrigins, origin)) {
+			return c.Next()
+		}
+
+		if anyOrigin {
+			c.Set(fiber.HeaderAccessControlAllowOrigin, "*")
+		} else {
+			c.Set(fiber.HeaderAccessControlAllowOrigin, origin)
+		}
+		if config.AllowCredentials {
+			c.Set(fiber.HeaderAccessControlAllowCredentials, "true")
+		}
+
+		// Preflight
+		if c.Method() == fiber.MethodOptions && c.Get(fiber.HeaderAccessControlRequestMethod) != "" {
+			c.Set(fiber.HeaderAccessControlAllowMethods, allowMethods)
+			if len(config.AllowHeaders) > 0 {
+				c.Set(fiber.HeaderAccessControlAllowHeaders, strings.Join(config.AllowHeaders, ", "))
+			} else if headers := c.Get(fiber.HeaderAccessControlRequestHeaders); headers != "" {
+				c.Set(fiber.HeaderAccessControlAllowHeaders, headers)
+			}
+			if config.MaxAge > 0 {
+				c.Set(fiber.HeaderAccessControlMaxAge, strconv.Itoa(config.MaxAge))
+			}
+			return c.SendStatus(fiber.StatusNoContent)
+		}
+
+		if len(config.ExposeHeaders) > 0 {
+			c.Set(fiber.HeaderAccessControlExposeHeaders, strings.Join(config.ExposeHeaders, ", "))
+		}
+		return c.Next()
+	}
+}
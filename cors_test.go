@@ -0,0 +1,125 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"strings"
+	"testing"
+)
+
+// corsApp serves widgets with config and a widgetsecret sibling without CORS, whose path widgets' is a prefix of
+func corsApp(config *CORSConfig) *fiber.App {
+	api := widgetApi(newWidgetStore(widget{ID: "a"}))
+	api.CORS = config
+	app := fiber.New()
+	RegisterAPI(app, api)
+	secret := widgetApi(newWidgetStore(widget{ID: "s"}))
+	secret.Path = "widgetsecret"
+	RegisterAPI(app, secret)
+	return app
+}
+
+func TestCORSPreflight(t *testing.T) {
+	app := corsApp(&CORSConfig{
+		AllowOrigins:     []string{"https://a.example"},
+		AllowHeaders:     []string{"Content-Type"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	})
+
+	tests := []struct {
+		target  string
+		origin  string
+		allowed bool
+	}{
+		{target: "/widgets/a", origin: "https://a.example", allowed: true},
+		{target: "/widgets/", origin: "https://a.example", allowed: true},
+		{target: "/widgets/a", origin: "https://evil.example"},
+		{target: "/widgetsecret/s", origin: "https://a.example"},
+	}
+	for _, tt := range tests {
+		resp, body := call(t, app, fiber.MethodOptions, tt.target, "",
+			fiber.HeaderOrigin, tt.origin, fiber.HeaderAccessControlRequestMethod, fiber.MethodPut)
+		origin := resp.Header.Get(fiber.HeaderAccessControlAllowOrigin)
+		methods := resp.Header.Get(fiber.HeaderAccessControlAllowMethods)
+		if !tt.allowed {
+			if origin != "" || methods != "" {
+				t.Errorf("OPTIONS %s from %s: allowed %q to %q", tt.target, tt.origin, origin, methods)
+			}
+			continue
+		}
+		expectStatus(t, resp, body, fiber.StatusNoContent)
+		if origin != tt.origin || resp.Header.Get(fiber.HeaderAccessControlAllowCredentials) != "true" {
+			t.Errorf("OPTIONS %s: allowed origin %q, credentials %q", tt.target, origin, resp.Header.Get(fiber.HeaderAccessControlAllowCredentials))
+		}
+		for _, method := range []string{fiber.MethodOptions, fiber.MethodGet, fiber.MethodPost, fiber.MethodPut, fiber.MethodDelete} {
+			if !strings.Contains(methods, method) {
+				t.Errorf("OPTIONS %s: allowed methods %q lack %s", tt.target, methods, method)
+			}
+		}
+		if headers := resp.Header.Get(fiber.HeaderAccessControlAllowHeaders); headers != "Content-Type" {
+			t.Errorf("OPTIONS %s: allowed headers %q", tt.target, headers)
+		}
+		if maxAge := resp.Header.Get(fiber.HeaderAccessControlMaxAge); maxAge != "600" {
+			t.Errorf("OPTIONS %s: max age %q", tt.target, maxAge)
+		}
+	}
+}
+
+func TestCORSSimpleRequest(t *testing.T) {
+	app := corsApp(&CORSConfig{ExposeHeaders: []string{fiber.HeaderETag}})
+
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/a", "", fiber.HeaderOrigin, "https://b.example")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if origin := resp.Header.Get(fiber.HeaderAccessControlAllowOrigin); origin != "*" {
+		t.Errorf("allowed origin %q, want any", origin)
+	}
+	if expose := resp.Header.Get(fiber.HeaderAccessControlExposeHeaders); expose != fiber.HeaderETag {
+		t.Errorf("exposed headers %q", expose)
+	}
+	if vary := resp.Header.Get(fiber.HeaderVary); !strings.Contains(vary, fiber.HeaderOrigin) {
+		t.Errorf("Vary %q lacks Origin", vary)
+	}
+
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/a", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if origin := resp.Header.Get(fiber.HeaderAccessControlAllowOrigin); origin != "" {
+		t.Errorf("allowed origin %q without an Origin", origin)
+	}
+
+	resp, body = call(t, app, fiber.MethodGet, "/widgetsecret/s", "", fiber.HeaderOrigin, "https://b.example")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if origin := resp.Header.Get(fiber.HeaderAccessControlAllowOrigin); origin != "" {
+		t.Errorf("the sibling without CORS allowed origin %q", origin)
+	}
+}
+
+func TestCORSCredentialsFromAnyOrigin(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("credentials from any origin registered, want a panic")
+		}
+	}()
+	corsApp(&CORSConfig{AllowCredentials: true})
+}
@@ -0,0 +1,112 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package easyresttest checks the wiring of easyrest Apis from tests
+package easyresttest
+
+import (
+	"github.com/chack1920/fiber-easyrest"
+	"github.com/gofiber/fiber/v2"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// suiteID fills the path parameters of the requests of TestSuite
+const suiteID = "easyrest-testsuite"
+
+// suiteUndeniedPaths are the routes that succeed for a denied caller,
+// the schema is public and mget skips the items the Validator rejects
+var suiteUndeniedPaths = []string{"/schema", "/mget"}
+
+// suiteArrayPaths are the routes whose body is an array of Dtos rather than a single one
+var suiteArrayPaths = []string{"/batch"}
+
+// suiteStreamPaths are the routes that stream until the client disconnects, which TestSuite cannot exercise
+var suiteStreamPaths = []string{"/events"}
+
+// TestSuite checks the wiring of api, registered on app.
+// It asserts every route of api is registered on app, then issues a representative request to each route of api,
+// mounted on a fresh app, once with a Validator allowing everything and once with one denying everything.
+// Allowed requests must not fail with a server error, denied requests must be 401 (Unauthorized) or 403 (Forbidden),
+// so that a request failing for another reason, e.g. a 400 parsing its body, does not pass as denied.
+// The data functions of api are really called, so only use it with test data.
+func TestSuite(t testing.TB, app *fiber.App, api easyrest.AnyApi) {
+	t.Helper()
+
+	routes := api.Routes()
+	registered := app.GetRoutes()
+	for _, r := range routes {
+		path := strings.TrimSuffix(api.Prefix()+r.Path, "/")
+		found := false
+		for _, candidate := range registered {
+			if candidate.Method == r.Method && strings.HasSuffix(strings.TrimSuffix(candidate.Path, "/"), path) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("%s %s is not registered", r.Method, path)
+		}
+	}
+
+	for _, allowed := range []bool{true, false} {
+		mounted := fiber.New()
+		api.Allowing(allowed).Register(mounted)
+		for _, r := range routes {
+			if slices.Contains(suiteStreamPaths, r.Path) {
+				continue
+			}
+			target := api.Prefix() + suitePath(r.Path)
+			body := "{}"
+			if slices.Contains(suiteArrayPaths, r.Path) {
+				body = "[]"
+			}
+			req := httptest.NewRequest(r.Method, target, strings.NewReader(body))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			resp, err := mounted.Test(req)
+			if err != nil {
+				t.Errorf("%s %s: %v", r.Method, target, err)
+				continue
+			}
+			switch {
+			case allowed && resp.StatusCode >= fiber.StatusInternalServerError:
+				t.Errorf("%s %s allowed: status %d", r.Method, target, resp.StatusCode)
+			case !allowed && !slices.Contains(suiteUndeniedPaths, r.Path) &&
+				resp.StatusCode != fiber.StatusUnauthorized && resp.StatusCode != fiber.StatusForbidden:
+				t.Errorf("%s %s denied: status %d, want 401 or 403", r.Method, target, resp.StatusCode)
+			}
+		}
+	}
+}
+
+// suitePath fills the parameters of a route path with suiteID
+func suitePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			segments[i] = suiteID
+		}
+	}
+	return strings.Join(segments, "/")
+}
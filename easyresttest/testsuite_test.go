@@ -0,0 +1,149 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyresttest
+
+import (
+	"fmt"
+	"github.com/chack1920/fiber-easyrest"
+	"github.com/gofiber/fiber/v2"
+	"sort"
+	"sync"
+	"testing"
+)
+
+type gadget struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// gadgetApi serves an in memory collection of gadgets, with the item, collection and filter routes.
+// The item suiteID is present so the item routes of TestSuite find it.
+func gadgetApi() easyrest.Api[gadget, gadget] {
+	var mu sync.Mutex
+	items := map[string]gadget{"g1": {ID: "g1", Name: "one"}, suiteID: {ID: suiteID, Name: "suite"}}
+	all := func() []gadget {
+		mu.Lock()
+		defer mu.Unlock()
+		var found []gadget
+		for _, g := range items {
+			found = append(found, g)
+		}
+		sort.Slice(found, func(i, j int) bool { return found[i].ID < found[j].ID })
+		return found
+	}
+	return easyrest.Api[gadget, gadget]{
+		Path: "gadgets",
+		Find: func(id string) (gadget, bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			g, ok := items[id]
+			return g, ok
+		},
+		FindAll: all,
+		Search: func(filter gadget) []gadget {
+			return all()
+		},
+		Create: func(g gadget) (gadget, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if g.ID == "" {
+				g.ID = fmt.Sprintf("g%d", len(items)+1)
+			}
+			items[g.ID] = g
+			return g, nil
+		},
+		Mutate: func(g gadget, d gadget) (gadget, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			g.Name = d.Name
+			items[g.ID] = g
+			return g, nil
+		},
+		Delete: func(g gadget) (gadget, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			delete(items, g.ID)
+			return g, nil
+		},
+		Dto: func(g gadget) gadget { return g },
+	}
+}
+
+// recorder is a testing.TB collecting the failures TestSuite reports rather than failing the test
+type recorder struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+// runSuite runs TestSuite against api registered on a fresh app, returning the failures it reported
+func runSuite(t *testing.T, api easyrest.Api[gadget, gadget]) []string {
+	app := fiber.New()
+	easyrest.RegisterAPI(app, api)
+	r := &recorder{TB: t}
+	TestSuite(r, app, api)
+	return r.failures
+}
+
+func TestSuitePasses(t *testing.T) {
+	if failures := runSuite(t, gadgetApi()); len(failures) > 0 {
+		t.Errorf("the suite failed a correct Api: %q", failures)
+	}
+}
+
+func TestSuiteFails(t *testing.T) {
+	tests := []struct {
+		name   string
+		action easyrest.Action
+		status int
+	}{
+		// A denied action that succeeds anyway
+		{name: "denied succeeds", action: easyrest.ActionGetAll, status: fiber.StatusOK},
+		// A denied action failing for another reason is not a denial either
+		{name: "denied is a bad request", action: easyrest.ActionCreate, status: fiber.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := gadgetApi()
+			api.Middleware = map[easyrest.Action][]fiber.Handler{
+				tt.action: {func(c *fiber.Ctx) error { return c.SendStatus(tt.status) }},
+			}
+			if failures := runSuite(t, api); len(failures) == 0 {
+				t.Errorf("the suite passed an Api answering %s with %d regardless", tt.action, tt.status)
+			}
+		})
+	}
+
+	// A route that is not registered on the app
+	app := fiber.New()
+	r := &recorder{TB: t}
+	TestSuite(r, app, gadgetApi())
+	if len(r.failures) == 0 {
+		t.Error("the suite passed an Api not registered on the app")
+	}
+}
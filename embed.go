@@ -0,0 +1,155 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+)
+
+// Embed is a child of the children of a SubEntity, or of another Embed, that ?embed= can nest under them
+// with a dot separated name, e.g. ?embed=orders.lines.  The embedded children carry theirs in an _embedded field.
+type Embed struct {
+	SubPath string
+	Get     func(parent any) []any
+	Dto     func(child any) any // Transform each child for the JSON, if nil the children are sent as is
+	Embeds  []Embed             // The children of these children that may be embedded in turn
+}
+
+// dto transforms the child with the Dto if set
+func (embed Embed) dto(child any) any {
+	if embed.Dto == nil {
+		return child
+	}
+	return embed.Dto(child)
+}
+
+// embedding is a SubEntity requested with ?embed=, with the Embeds requested under its children
+type embedding[T any, D any] struct {
+	subEntity SubEntity[T, D]
+	nested    embedTree
+}
+
+// embedTree is the Embeds requested under a child, by SubPath, with those requested under theirs
+type embedTree map[string]embedTree
+
+// embeds reads the SubEntities requested with ?embed=, a comma separated list of SubPaths.
+// The Embeds of their children are requested with dot separated names, e.g. orders.lines.
+// A name nested deeper than the MaxEmbedDepth, or that does not name a SubEntity and its Embeds, is an error.
+func (api Api[T, D]) embeds(c *fiber.Ctx) ([]embedding[T, D], error) {
+	maxDepth := api.MaxEmbedDepth
+	if maxDepth <= 0 {
+		maxDepth = 1
+	}
+	var embeds []embedding[T, D]
+	for _, name := range strings.Split(c.Query("embed"), ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		path := strings.Split(name, ".")
+		if len(path) > maxDepth {
+			return nil, fmt.Errorf("cannot embed %s, embeds are limited to a depth of %d", name, maxDepth)
+		}
+		subEntity, ok := api.subEntity(path[0])
+		if !ok {
+			return nil, fmt.Errorf("cannot embed %s", name)
+		}
+		// Merge the path into the embedding of its SubEntity
+		i := 0
+		for i < len(embeds) && embeds[i].subEntity.SubPath != subEntity.SubPath {
+			i++
+		}
+		if i == len(embeds) {
+			embeds = append(embeds, embedding[T, D]{subEntity: subEntity, nested: embedTree{}})
+		}
+		tree, available := embeds[i].nested, subEntity.Embeds
+		for _, segment := range path[1:] {
+			embed, ok := findEmbed(available, segment)
+			if !ok {
+				return nil, fmt.Errorf("cannot embed %s", name)
+			}
+			if tree[segment] == nil {
+				tree[segment] = embedTree{}
+			}
+			tree, available = tree[segment], embed.Embeds
+		}
+	}
+	return embeds, nil
+}
+
+// findEmbed finds the Embed with the SubPath
+func findEmbed(embeds []Embed, subPath string) (Embed, bool) {
+	for _, embed := range embeds {
+		if embed.SubPath == subPath {
+			return embed, true
+		}
+	}
+	return Embed{}, false
+}
+
+// subEntity finds the enabled SubEntity with the SubPath
+func (api Api[T, D]) subEntity(subPath string) (SubEntity[T, D], bool) {
+	for _, subEntity := range api.SubEntities {
+		if subEntity.SubPath == subPath && !subEntity.Disabled {
+			return subEntity, true
+		}
+	}
+	return SubEntity[T, D]{}, false
+}
+
+// embedded lists the children of item in each of the embeds, by SubPath, as the _embedded field of a response
+func (api Api[T, D]) embedded(item T, embeds []embedding[T, D]) map[string]any {
+	if len(embeds) == 0 {
+		return nil
+	}
+	embedded := make(map[string]any, len(embeds))
+	for _, e := range embeds {
+		embedded[e.subEntity.SubPath] = api.embedChildren(e.subEntity.Get(item), e.subEntity.dto, e.subEntity.Embeds, e.nested)
+	}
+	return map[string]any{"_embedded": embedded}
+}
+
+// embedChildren transforms the children with dto, embedding the nested Embeds of the available ones in each
+func (api Api[T, D]) embedChildren(children []any, dto func(any) any, available []Embed, nested embedTree) []any {
+	dtos := make([]any, len(children))
+	for i, child := range children {
+		dtos[i] = dto(child)
+		if len(nested) == 0 {
+			continue
+		}
+		object, ok := api.jsonObject(dtos[i])
+		if !ok {
+			// Only objects can carry an _embedded field
+			continue
+		}
+		embedded := make(map[string]any, len(nested))
+		for subPath, tree := range nested {
+			embed, _ := findEmbed(available, subPath)
+			embedded[subPath] = api.embedChildren(embed.Get(child), embed.dto, embed.Embeds, tree)
+		}
+		object["_embedded"] = embedded
+		dtos[i] = object
+	}
+	return dtos
+}
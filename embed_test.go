@@ -0,0 +1,106 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"encoding/json"
+	"github.com/gofiber/fiber/v2"
+	"testing"
+)
+
+// embedApi is a widget Api with parts embedding their screws
+func embedApi(maxDepth int) Api[widget, widgetDto] {
+	api := widgetApi(newWidgetStore(widget{ID: "a", Name: "alpha"}))
+	api.MaxEmbedDepth = maxDepth
+	api.SubEntities = []SubEntity[widget, widgetDto]{{
+		SubPath: "parts",
+		Get:     widgetParts,
+		Embeds: []Embed{{
+			SubPath: "screws",
+			Get: func(parent any) []any {
+				return []any{map[string]string{"id": parent.(part).ID + "-s"}}
+			},
+		}},
+	}}
+	return api
+}
+
+func TestMaxEmbedDepth(t *testing.T) {
+	tests := []struct {
+		maxDepth int
+		embed    string
+		status   int
+		want     string
+	}{
+		{maxDepth: 0, embed: "parts", status: fiber.StatusOK,
+			want: `{"_embedded":{"parts":[{"id":"a-1","widget":"a"},{"id":"a-2","widget":"a"}]},"id":"a","name":"alpha","status":""}`},
+		{maxDepth: 0, embed: "parts.screws", status: fiber.StatusBadRequest},
+		{maxDepth: 2, embed: "parts.screws", status: fiber.StatusOK,
+			want: `{"_embedded":{"parts":[{"_embedded":{"screws":[{"id":"a-1-s"}]},"id":"a-1","widget":"a"},{"_embedded":{"screws":[{"id":"a-2-s"}]},"id":"a-2","widget":"a"}]},"id":"a","name":"alpha","status":""}`},
+		{maxDepth: 2, embed: "parts.screws.threads", status: fiber.StatusBadRequest},
+		{maxDepth: 3, embed: "parts.bolts", status: fiber.StatusBadRequest},
+		{maxDepth: 1, embed: "owners", status: fiber.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		resp, body := call(t, serve(embedApi(tt.maxDepth)), fiber.MethodGet, "/widgets/a?embed="+tt.embed, "")
+		expectStatus(t, resp, body, tt.status)
+		if tt.want != "" && body != tt.want {
+			t.Errorf("embedding %s: %s, want %s", tt.embed, body, tt.want)
+		}
+	}
+}
+
+func TestEmbedOnCreate(t *testing.T) {
+	api := embedApi(2)
+	app := serve(api)
+
+	resp, body := call(t, app, fiber.MethodPost, "/widgets/?embed=parts", `{"id":"new","name":"fresh"}`)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if want := `{"_embedded":{"parts":[{"id":"new-1","widget":"new"},{"id":"new-2","widget":"new"}]},"id":"new","name":"fresh","status":""}`; body != want {
+		t.Errorf("body %s, want %s", body, want)
+	}
+
+	resp, body = call(t, app, fiber.MethodPost, "/widgets/?embed=owners", `{"id":"other"}`)
+	expectStatus(t, resp, body, fiber.StatusBadRequest)
+	if _, ok := api.Find("other"); ok {
+		t.Error("an invalid embed still created the item")
+	}
+}
+
+func TestEmbedMarshal(t *testing.T) {
+	api := embedApi(2)
+	// Parts are serialized as references, an embedding part must be too
+	api.Marshal = func(v any) ([]byte, error) {
+		if p, ok := v.(part); ok {
+			return json.Marshal(map[string]string{"ref": p.ID})
+		}
+		return json.Marshal(v)
+	}
+
+	resp, body := call(t, serve(api), fiber.MethodGet, "/widgets/a?embed=parts.screws", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	want := `{"_embedded":{"parts":[{"_embedded":{"screws":[{"id":"a-1-s"}]},"ref":"a-1"},{"_embedded":{"screws":[{"id":"a-2-s"}]},"ref":"a-2"}]},"id":"a","name":"alpha","status":""}`
+	if body != want {
+		t.Errorf("body %s, want %s", body, want)
+	}
+}
@@ -0,0 +1,74 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrConflict may be returned, or wrapped, by the create functions when the item already exists.
+// The Api answers it with 409 (Conflict).
+var ErrConflict = errors.New("item already exists")
+
+// ErrNotFound may be returned, or wrapped, by the data functions when the item they act on does not exist.
+// The Api answers it with 404 (Not Found).
+var ErrNotFound = errors.New("item not found")
+
+// ErrOverloaded may be returned, or wrapped, by the data functions to signal the backing store is too busy.
+// The Api answers it with 503 (Service Unavailable).
+var ErrOverloaded = errors.New("backing store overloaded")
+
+// OverloadedError is an ErrOverloaded that also suggests how long clients should wait before retrying.
+// The wait is sent in the Retry-After header.
+type OverloadedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *OverloadedError) Error() string {
+	return fmt.Sprintf("%v, retry after %v", ErrOverloaded, e.RetryAfter)
+}
+
+// Is makes errors.Is(err, ErrOverloaded) hold for an OverloadedError
+func (e *OverloadedError) Is(target error) bool {
+	return target == ErrOverloaded
+}
+
+// ValidationError may be returned by Create or Mutate when business rules reject the submitted fields.
+// It is answered with 400 (Bad Request) and the body {"errors": Fields}, an errors member of the problem details
+// if EnableProblemJSON is set.
+type ValidationError struct {
+	Fields map[string]string // Message for each bad field
+}
+
+func (e *ValidationError) Error() string {
+	fields := make([]string, 0, len(e.Fields))
+	for field, message := range e.Fields {
+		fields = append(fields, field+": "+message)
+	}
+	slices.Sort(fields)
+	return "validation failed: " + strings.Join(fields, ", ")
+}
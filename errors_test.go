@@ -0,0 +1,93 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOverloaded(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		mapper     func(err error) int
+		status     int
+		retryAfter string
+	}{
+		{name: "sentinel", err: ErrOverloaded, status: fiber.StatusServiceUnavailable},
+		{name: "retry after", err: &OverloadedError{RetryAfter: 1500 * time.Millisecond}, status: fiber.StatusServiceUnavailable, retryAfter: "2"},
+		{name: "wrapped", err: fmt.Errorf("saving: %w", &OverloadedError{RetryAfter: 30 * time.Second}), status: fiber.StatusServiceUnavailable, retryAfter: "30"},
+		{name: "mapped", err: ErrOverloaded, mapper: func(err error) int { return fiber.StatusTooManyRequests }, status: fiber.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := widgetApi(newWidgetStore())
+			api.Create = func(d widgetDto) (widget, error) {
+				return widget{}, tt.err
+			}
+			api.ErrorMapper = tt.mapper
+			resp, body := call(t, serve(api), fiber.MethodPost, "/widgets/", `{"id":"a"}`)
+			expectStatus(t, resp, body, tt.status)
+			if got := resp.Header.Get(fiber.HeaderRetryAfter); got != tt.retryAfter {
+				t.Errorf("Retry-After %q, want %q", got, tt.retryAfter)
+			}
+		})
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	fields := map[string]string{"name": "is required", "status": "must be active or archived"}
+	api := widgetApi(newWidgetStore())
+	api.Create = func(d widgetDto) (widget, error) {
+		return widget{}, fmt.Errorf("creating: %w", &ValidationError{Fields: fields})
+	}
+
+	resp, body := call(t, serve(api), fiber.MethodPost, "/widgets/", `{"id":"a"}`)
+	expectStatus(t, resp, body, fiber.StatusBadRequest)
+	var invalid struct {
+		Errors map[string]string `json:"errors"`
+	}
+	decodeBody(t, body, &invalid)
+	if !reflect.DeepEqual(invalid.Errors, fields) {
+		t.Errorf("errors %v, want %v", invalid.Errors, fields)
+	}
+
+	api.EnableProblemJSON = true
+	resp, body = call(t, serve(api), fiber.MethodPost, "/widgets/", `{"id":"a"}`)
+	expectStatus(t, resp, body, fiber.StatusBadRequest)
+	if ctype := resp.Header.Get(fiber.HeaderContentType); ctype != MIMEApplicationProblemJSON {
+		t.Errorf("content type %q, want problem details", ctype)
+	}
+	var problem struct {
+		Status int               `json:"status"`
+		Errors map[string]string `json:"errors"`
+	}
+	decodeBody(t, body, &problem)
+	if problem.Status != fiber.StatusBadRequest || !reflect.DeepEqual(problem.Errors, fields) {
+		t.Errorf("problem %s, want the status and the errors member", body)
+	}
+}
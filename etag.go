@@ -0,0 +1,63 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"strconv"
+	"strings"
+)
+
+// entityTag formats value as an entity tag, a weak one carries the W/ prefix
+func entityTag(value string, weak bool) string {
+	if weak {
+		return "W/" + strconv.Quote(value)
+	}
+	return strconv.Quote(value)
+}
+
+// weakMatch reports if any entity tag listed in header matches etag using the weak comparison of RFC 7232,
+// which ignores the W/ prefix.  This is the comparison If-None-Match uses.
+func weakMatch(header string, etag string) bool {
+	return matchTags(header, func(tag string) bool {
+		return strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/")
+	})
+}
+
+// strongMatch reports if any entity tag listed in header matches etag using the strong comparison of RFC 7232,
+// where weak tags never match.  This is the comparison If-Match uses.
+func strongMatch(header string, etag string) bool {
+	return matchTags(header, func(tag string) bool {
+		return !strings.HasPrefix(tag, "W/") && !strings.HasPrefix(etag, "W/") && tag == etag
+	})
+}
+
+// matchTags reports if "*" or any tag in the comma separated header passes match
+func matchTags(header string, match func(tag string) bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || (tag != "" && match(tag)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"testing"
+)
+
+func TestEntityTagMatch(t *testing.T) {
+	tests := []struct {
+		header string
+		etag   string
+		weak   bool
+		strong bool
+	}{
+		{header: `"v1"`, etag: `"v1"`, weak: true, strong: true},
+		{header: `W/"v1"`, etag: `"v1"`, weak: true},
+		{header: `"v1"`, etag: `W/"v1"`, weak: true},
+		{header: `W/"v1"`, etag: `W/"v1"`, weak: true},
+		{header: `"v0", "v1"`, etag: `"v1"`, weak: true, strong: true},
+		{header: `*`, etag: `"v1"`, weak: true, strong: true},
+		{header: `"v2"`, etag: `"v1"`},
+		{header: ``, etag: `"v1"`},
+	}
+	for _, tt := range tests {
+		if got := weakMatch(tt.header, tt.etag); got != tt.weak {
+			t.Errorf("weakMatch(%s, %s) = %v, want %v", tt.header, tt.etag, got, tt.weak)
+		}
+		if got := strongMatch(tt.header, tt.etag); got != tt.strong {
+			t.Errorf("strongMatch(%s, %s) = %v, want %v", tt.header, tt.etag, got, tt.strong)
+		}
+	}
+}
+
+func TestItemETag(t *testing.T) {
+	store := newWidgetStore(widget{ID: "a", Name: "alpha"})
+	api := widgetApi(store)
+	api.Version = func(w widget) string { return w.Name }
+	app := serve(api)
+
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/a", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if etag := resp.Header.Get(fiber.HeaderETag); etag != `"alpha"` {
+		t.Fatalf("ETag %q, want the strong version tag", etag)
+	}
+
+	// If-None-Match compares weakly, so the weak form of the tag matches too
+	for _, tag := range []string{`"alpha"`, `W/"alpha"`, `"old", "alpha"`} {
+		resp, body = call(t, app, fiber.MethodGet, "/widgets/a", "", fiber.HeaderIfNoneMatch, tag)
+		expectStatus(t, resp, body, fiber.StatusNotModified)
+	}
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/a", "", fiber.HeaderIfNoneMatch, `"old"`)
+	expectStatus(t, resp, body, fiber.StatusOK)
+
+	// If-Match compares strongly, so the weak form does not
+	api.RequireIfMatch = true
+	app = serve(api)
+	resp, body = call(t, app, fiber.MethodPut, "/widgets/a", `{"name":"beta"}`, fiber.HeaderIfMatch, `W/"alpha"`)
+	expectStatus(t, resp, body, fiber.StatusPreconditionFailed)
+	resp, body = call(t, app, fiber.MethodPut, "/widgets/a", `{"name":"beta"}`, fiber.HeaderIfMatch, `"alpha"`)
+	expectStatus(t, resp, body, fiber.StatusOK)
+}
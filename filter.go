@@ -0,0 +1,199 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"fmt"
+	"github.com/gofiber/fiber/v2"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// filterDateLayouts are the layouts BindFilter accepts for time.Time fields, in order
+var filterDateLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"}
+
+// BindFilter binds the query string of c into a filter of type D, coercing the values to the field types.
+// Fields are named by their `query` tag, then their `json` tag, then their name.
+// Booleans, numbers, strings, time.Time as RFC 3339 or a 2006-01-02 date, pointers to them and slices of them,
+// from repeated or comma separated values, are supported.  A value that does not parse is an error naming the field.
+func BindFilter[D any](c *fiber.Ctx) (D, error) {
+	var filter D
+	v := reflect.ValueOf(&filter).Elem()
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return filter, fmt.Errorf("cannot bind a query to %s", v.Type())
+	}
+
+	args := c.Context().QueryArgs()
+	if err := bindFields(v, func(name string) []string {
+		var values []string
+		for _, value := range args.PeekMulti(name) {
+			values = append(values, string(value))
+		}
+		return values
+	}); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// filteredFields lists the names of the fields of struct type t the query string of c binds, see BindFilter
+func filteredFields(c *fiber.Ctx, t reflect.Type) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	var names []string
+	args := c.Context().QueryArgs()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			names = append(names, filteredFields(c, f.Type)...)
+			continue
+		}
+		if name := filterName(f); f.IsExported() && name != "-" && args.Has(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// bindFields sets the fields of struct v from the values lookup returns for their names
+func bindFields(v reflect.Value, lookup func(name string) []string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if err := bindFields(v.Field(i), lookup); err != nil {
+				return err
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		name := filterName(f)
+		if name == "-" {
+			continue
+		}
+		values := lookup(name)
+		if len(values) == 0 {
+			continue
+		}
+		if err := setFilterValue(v.Field(i), values); err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// filterName is the query parameter name of field f
+func filterName(f reflect.StructField) string {
+	for _, key := range []string{"query", "json"} {
+		if name, _, _ := strings.Cut(f.Tag.Get(key), ","); name != "" {
+			return name
+		}
+	}
+	return f.Name
+}
+
+// setFilterValue sets field from the query values, splitting them on commas for slices
+func setFilterValue(field reflect.Value, values []string) error {
+	switch {
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() != reflect.Uint8:
+		var items []string
+		for _, value := range values {
+			items = append(items, strings.Split(value, ",")...)
+		}
+		slice := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setFilterScalar(slice.Index(i), item); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	case field.Kind() == reflect.Pointer:
+		value := reflect.New(field.Type().Elem())
+		if err := setFilterScalar(value.Elem(), values[0]); err != nil {
+			return err
+		}
+		field.Set(value)
+		return nil
+	default:
+		return setFilterScalar(field, values[0])
+	}
+}
+
+// setFilterScalar sets a single valued field from its query value
+func setFilterScalar(field reflect.Value, value string) error {
+	if field.Type() == timeType {
+		for _, layout := range filterDateLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				field.Set(reflect.ValueOf(t))
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not a date", value)
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not a positive integer", value)
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		field.SetFloat(n)
+	default:
+		return fmt.Errorf("cannot bind a query to %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rangeFilter is a filter bound from the query string
+type rangeFilter struct {
+	From    time.Time  `query:"from"`
+	To      *time.Time `json:"to"`
+	Active  bool       `query:"active"`
+	Min     int        `query:"min"`
+	Tags    []string   `query:"tag"`
+	Ignored string     `query:"-"`
+}
+
+// bindQuery binds the query string of target with BindFilter
+func bindQuery(t *testing.T, target string) (rangeFilter, error) {
+	t.Helper()
+	var filter rangeFilter
+	var err error
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		filter, err = BindFilter[rangeFilter](c)
+		return nil
+	})
+	if _, testErr := app.Test(httptest.NewRequest(fiber.MethodGet, target, nil)); testErr != nil {
+		t.Fatal(testErr)
+	}
+	return filter, err
+}
+
+func TestBindFilter(t *testing.T) {
+	filter, err := bindQuery(t, "/?from=2024-01-02&to=2024-02-03T04:05:06Z&active=true&min=3&tag=a,b&tag=c&Ignored=x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)
+	if !filter.From.Equal(from) || filter.To == nil || !filter.To.Equal(to) {
+		t.Errorf("date range %v - %v, want %v - %v", filter.From, filter.To, from, to)
+	}
+	if !filter.Active || filter.Min != 3 || !slices.Equal(filter.Tags, []string{"a", "b", "c"}) || filter.Ignored != "" {
+		t.Errorf("bound %+v", filter)
+	}
+
+	filter, err = bindQuery(t, "/?active=false")
+	if err != nil || filter.Active || filter.To != nil {
+		t.Errorf("bound %+v, %v, want an inactive filter without an end", filter, err)
+	}
+}
+
+func TestBindFilterErrors(t *testing.T) {
+	for target, field := range map[string]string{
+		"/?active=maybe":  "active",
+		"/?from=tomorrow": "from",
+		"/?min=3.5":       "min",
+	} {
+		if _, err := bindQuery(t, target); err == nil || !strings.Contains(err.Error(), field) {
+			t.Errorf("%s: error %v, want one naming %s", target, err, field)
+		}
+	}
+}
@@ -4,6 +4,7 @@ go 1.22.0
 
 require (
 	github.com/gofiber/fiber/v2 v2.52.0
+	golang.org/x/sync v0.8.0
 	gorm.io/gorm v1.25.7
 )
 
@@ -23,6 +23,7 @@
 package easyrest
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
@@ -31,6 +32,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Options for the exposed GORM backed REST API.
@@ -47,6 +49,7 @@ type Options[T any, D any] struct {
 	Mutate    bool                                              // Enable mutate
 	Create    bool                                              // Enable create
 	Validator func(c *fiber.Ctx, action Action, item ...T) bool // Validation function, item is empty if this is a find all query or an item is not found
+	Timeout   time.Duration                                     // Bound on the database work of each request, 504 is returned if exceeded
 }
 
 // DefaultOptions returns a basic configuration allowing all rest operations and with no authentication
@@ -101,16 +104,26 @@ func RegisterApi[T any, D any](app fiber.Router, db *gorm.DB, path string, optio
 		SubEntities: []SubEntity[T, D]{},
 		Validator:   impl.Validator,
 		Dto:         impl.copyToDto,
+		FindCtx:     impl.finderCtx,
+		FindAllCtx:  impl.findAllCtx,
+		SearchCtx:   impl.searchCtx,
+		MutateCtx:   impl.mutateCtx,
+		CreateCtx:   impl.createCtx,
+		DeleteCtx:   impl.deleteCtx,
+		Timeout:     options.Timeout,
 	}
 	// Remove any disabled options
 	if !options.Delete {
 		fullApi.Delete = nil
+		fullApi.DeleteCtx = nil
 	}
 	if !options.Mutate {
 		fullApi.Mutate = nil
+		fullApi.MutateCtx = nil
 	}
 	if !options.Create {
 		fullApi.Create = nil
+		fullApi.CreateCtx = nil
 	}
 
 	// Create the API child maps
@@ -129,6 +142,11 @@ func RegisterApi[T any, D any](app fiber.Router, db *gorm.DB, path string, optio
 // finder for single items.
 // Makes used of the gorm Find() function passing in a template object that has just the key set.
 func (a *grest[T, D]) finder(key string) (T, bool) {
+	return a.finderCtx(context.Background(), key)
+}
+
+// finderCtx is finder running the query under ctx.
+func (a *grest[T, D]) finderCtx(ctx context.Context, key string) (T, bool) {
 	// Create the template item
 	item, err := a.emptyWithKey(key)
 	if err != nil {
@@ -136,7 +154,7 @@ func (a *grest[T, D]) finder(key string) (T, bool) {
 	}
 	// Find it.
 	// Preload joined tables so that the object is fully populated.
-	tx := a.db.Preload(clause.Associations).Limit(1).Find(&item, &item)
+	tx := a.db.WithContext(ctx).Preload(clause.Associations).Limit(1).Find(&item, &item)
 
 	// Return the result or error
 	err2 := tx.Error
@@ -240,8 +258,13 @@ func (a *grest[T, D]) findAllPage(ID int64) Page[T] {
 }
 
 func (a *grest[T, D]) findAll() []T {
+	return a.findAllCtx(context.Background())
+}
+
+// findAllCtx is findAll running the query under ctx.
+func (a *grest[T, D]) findAllCtx(ctx context.Context) []T {
 	var all []T
-	a.db.Order("ID desc").Preload(clause.Associations).Find(&all)
+	a.db.WithContext(ctx).Order("ID desc").Preload(clause.Associations).Find(&all)
 	//fmt.Println(rest.RowsAffected)
 
 	return all
@@ -249,24 +272,39 @@ func (a *grest[T, D]) findAll() []T {
 
 // search uses the D as a filter, providing it as a mask to the gorm find function
 func (a *grest[T, D]) search(filter D) []T {
+	return a.searchCtx(context.Background(), filter)
+}
+
+// searchCtx is search running the query under ctx.
+func (a *grest[T, D]) searchCtx(ctx context.Context, filter D) []T {
 	tFilter := a.copyFromDto(a.emptyT, filter)
 	var all []T
-	a.db.Preload(clause.Associations).Find(&all, &tFilter)
+	a.db.WithContext(ctx).Preload(clause.Associations).Find(&all, &tFilter)
 	return all
 }
 
 // mutate takes a Dto of type D and applies it to an existing object of T.
 // T is then persisted in the DB.
 func (a *grest[T, D]) mutate(orig T, edit D) (T, error) {
+	return a.mutateCtx(context.Background(), orig, edit)
+}
+
+// mutateCtx is mutate running the save under ctx.
+func (a *grest[T, D]) mutateCtx(ctx context.Context, orig T, edit D) (T, error) {
 	// Copy the dto
 	orig = a.copyFromDto(orig, edit)
 	// Save it to the database
-	err := a.db.Save(&orig).Error
+	err := a.db.WithContext(ctx).Save(&orig).Error
 	return orig, err
 }
 
 // create inserts a new T built from a template T and D mutation + key field
 func (a *grest[T, D]) create(edit D) (T, error) {
+	return a.createCtx(context.Background(), edit)
+}
+
+// createCtx is create running the insert under ctx.
+func (a *grest[T, D]) createCtx(ctx context.Context, edit D) (T, error) {
 	// Create the new empty object with a key set
 	key := reflect.ValueOf(edit).FieldByIndex(a.dMap.dtoKey)
 	keyString := ""
@@ -286,7 +324,7 @@ func (a *grest[T, D]) create(edit D) (T, error) {
 		return ret, err
 	}
 	// Copy the data and save
-	return a.mutate(ret, edit)
+	return a.mutateCtx(ctx, ret, edit)
 }
 
 // copyToDto does the heavy lifting of "cloning" T into its Dto D.
@@ -351,7 +389,12 @@ func (a *grest[T, D]) copyFromDto(out T, in D) T {
 // delete simply using GORM to delete the specified item.
 // If gorm.Model is used then the object is not deleted, it is just marked as inactive in the database.
 func (a *grest[T, D]) delete(item T) (T, error) {
-	err := a.db.Delete(&item).Error
+	return a.deleteCtx(context.Background(), item)
+}
+
+// deleteCtx is delete running the statement under ctx.
+func (a *grest[T, D]) deleteCtx(ctx context.Context, item T) (T, error) {
+	err := a.db.WithContext(ctx).Delete(&item).Error
 	return item, err
 }
 
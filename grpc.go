@@ -0,0 +1,92 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"strconv"
+)
+
+// HeaderGRPCStatus is the response header carrying the gRPC status code when EmitGRPCStatus is set
+const HeaderGRPCStatus = "grpc-status"
+
+// gRPC status codes, see https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+const (
+	grpcOK                 = 0
+	grpcCancelled          = 1
+	grpcUnknown            = 2
+	grpcInvalidArgument    = 3
+	grpcDeadlineExceeded   = 4
+	grpcNotFound           = 5
+	grpcAlreadyExists      = 6
+	grpcPermissionDenied   = 7
+	grpcResourceExhausted  = 8
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnavailable        = 14
+	grpcUnauthenticated    = 16
+)
+
+// grpcStatuses maps the HTTP statuses the Api responds with to their gRPC equivalent
+var grpcStatuses = map[int]int{
+	fiber.StatusBadRequest:            grpcInvalidArgument,
+	fiber.StatusUnauthorized:          grpcUnauthenticated,
+	fiber.StatusForbidden:             grpcPermissionDenied,
+	fiber.StatusNotFound:              grpcNotFound,
+	fiber.StatusMethodNotAllowed:      grpcUnimplemented,
+	fiber.StatusConflict:              grpcAlreadyExists,
+	fiber.StatusGone:                  grpcNotFound,
+	fiber.StatusPreconditionFailed:    grpcFailedPrecondition,
+	fiber.StatusRequestEntityTooLarge: grpcResourceExhausted,
+	fiber.StatusUnsupportedMediaType:  grpcInvalidArgument,
+	fiber.StatusUnprocessableEntity:   grpcInvalidArgument,
+	fiber.StatusPreconditionRequired:  grpcFailedPrecondition,
+	fiber.StatusTooManyRequests:       grpcResourceExhausted,
+	499:                               grpcCancelled, // Client Closed Request
+	fiber.StatusInternalServerError:   grpcInternal,
+	fiber.StatusNotImplemented:        grpcUnimplemented,
+	fiber.StatusServiceUnavailable:    grpcUnavailable,
+	fiber.StatusGatewayTimeout:        grpcDeadlineExceeded,
+}
+
+// grpcStatus is the gRPC status code equivalent to the HTTP status.
+// Any success or redirect, such as 304 (Not Modified), is OK and unmapped errors are UNKNOWN.
+func grpcStatus(status int) int {
+	if status < fiber.StatusBadRequest {
+		return grpcOK
+	}
+	if code, ok := grpcStatuses[status]; ok {
+		return code
+	}
+	return grpcUnknown
+}
+
+// emitGRPCStatus sets the grpc-status header of the response of handler
+func emitGRPCStatus(handler fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		err := handler(c)
+		c.Set(HeaderGRPCStatus, strconv.Itoa(grpcStatus(c.Response().StatusCode())))
+		return err
+	}
+}
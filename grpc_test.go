@@ -0,0 +1,81 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"testing"
+)
+
+func TestGRPCStatus(t *testing.T) {
+	tests := map[int]int{
+		fiber.StatusOK:                  grpcOK,
+		fiber.StatusNoContent:           grpcOK,
+		fiber.StatusNotModified:         grpcOK,
+		fiber.StatusBadRequest:          grpcInvalidArgument,
+		fiber.StatusUnauthorized:        grpcUnauthenticated,
+		fiber.StatusForbidden:           grpcPermissionDenied,
+		fiber.StatusNotFound:            grpcNotFound,
+		fiber.StatusConflict:            grpcAlreadyExists,
+		fiber.StatusTooManyRequests:     grpcResourceExhausted,
+		fiber.StatusInternalServerError: grpcInternal,
+		fiber.StatusServiceUnavailable:  grpcUnavailable,
+		fiber.StatusGatewayTimeout:      grpcDeadlineExceeded,
+		fiber.StatusTeapot:              grpcUnknown,
+		fiber.StatusBadGateway:          grpcUnknown,
+	}
+	for status, want := range tests {
+		if got := grpcStatus(status); got != want {
+			t.Errorf("grpcStatus(%d) = %d, want %d", status, got, want)
+		}
+	}
+}
+
+func TestEmitGRPCStatus(t *testing.T) {
+	api := widgetApi(newWidgetStore(widget{ID: "a"}))
+	api.EmitGRPCStatus = true
+	api.Validator = func(c *fiber.Ctx, action Action, item ...widget) bool {
+		return action != ActionDelete
+	}
+	app := serve(api)
+
+	for _, tt := range []struct {
+		method, target string
+		grpc           string
+	}{
+		{method: fiber.MethodGet, target: "/widgets/a", grpc: "0"},
+		{method: fiber.MethodGet, target: "/widgets/missing", grpc: "5"},
+		{method: fiber.MethodDelete, target: "/widgets/a", grpc: "16"},
+	} {
+		resp, _ := call(t, app, tt.method, tt.target, "")
+		if got := resp.Header.Get(HeaderGRPCStatus); got != tt.grpc {
+			t.Errorf("%s %s: %s %q, want %q", tt.method, tt.target, HeaderGRPCStatus, got, tt.grpc)
+		}
+	}
+
+	api.EmitGRPCStatus = false
+	resp, _ := call(t, serve(api), fiber.MethodGet, "/widgets/a", "")
+	if got := resp.Header.Get(HeaderGRPCStatus); got != "" {
+		t.Errorf("%s %q set without EmitGRPCStatus", HeaderGRPCStatus, got)
+	}
+}
@@ -0,0 +1,119 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"crypto/sha256"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"sync"
+	"time"
+)
+
+// HeaderIdempotencyKey names a create so that its retries are answered with the response of the first
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+// HeaderIdempotentReplayed flags a response replayed for a retried Idempotency-Key
+const HeaderIdempotentReplayed = "Idempotent-Replayed"
+
+// idempotencyStore holds the responses of the creates made with an Idempotency-Key for the IdempotencyTTL, by key
+type idempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]idempotentResponse
+}
+
+// idempotentResponse is the response of a create as sent, with a digest of the request body it answered
+type idempotentResponse struct {
+	digest      [sha256.Size]byte
+	status      int
+	body        []byte
+	contentType string
+	location    string
+	expires     time.Time
+}
+
+// get returns the unexpired response stored under key
+func (s *idempotencyStore) get(key string) (idempotentResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	response, ok := s.entries[key]
+	if !ok || time.Now().After(response.expires) {
+		delete(s.entries, key)
+		return idempotentResponse{}, false
+	}
+	return response, true
+}
+
+// put stores response under key
+func (s *idempotencyStore) put(key string, response idempotentResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entries == nil {
+		s.entries = map[string]idempotentResponse{}
+	}
+	s.entries[key] = response
+}
+
+// replayCreate answers a create retried with the Idempotency-Key of an earlier successful one with its response.
+// A retry with a different body is 422 (Unprocessable Entity), as the key was reused for another create.
+func (api Api[T, D]) replayCreate(c *fiber.Ctx) (bool, error) {
+	key := c.Get(HeaderIdempotencyKey)
+	if api.IdempotencyTTL <= 0 || key == "" {
+		return false, nil
+	}
+	response, ok := api.mount.idempotent.get(key)
+	if !ok {
+		return false, nil
+	}
+	if response.digest != sha256.Sum256(c.Body()) {
+		return true, api.sendError(c, fiber.StatusUnprocessableEntity,
+			errors.New("the Idempotency-Key was used for a different request"))
+	}
+	c.Set(HeaderIdempotentReplayed, "true")
+	if response.location != "" {
+		c.Location(response.location)
+	}
+	if response.contentType != "" {
+		c.Set(fiber.HeaderContentType, response.contentType)
+	}
+	return true, c.Status(response.status).Send(response.body)
+}
+
+// recordCreate stores the response of a successful create made with an Idempotency-Key for its retries
+func (api Api[T, D]) recordCreate(c *fiber.Ctx) {
+	key := c.Get(HeaderIdempotencyKey)
+	status := c.Response().StatusCode()
+	if api.IdempotencyTTL <= 0 || key == "" || status >= fiber.StatusMultipleChoices {
+		return
+	}
+	// The key is copied, the header is only valid for the request
+	api.mount.idempotent.put(utils.CopyString(key), idempotentResponse{
+		digest:      sha256.Sum256(c.Body()),
+		status:      status,
+		body:        append([]byte(nil), c.Response().Body()...),
+		contentType: string(c.Response().Header.ContentType()),
+		location:    string(c.Response().Header.Peek(fiber.HeaderLocation)),
+		expires:     time.Now().Add(api.IdempotencyTTL),
+	})
+}
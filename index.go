@@ -0,0 +1,100 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"context"
+	"github.com/gofiber/fiber/v2"
+	"strings"
+	"sync"
+)
+
+// indexCache holds the Indexes built from FindAll, by field, until a write invalidates them
+type indexCache struct {
+	mu    sync.Mutex
+	built map[string]any
+}
+
+// clear drops every built index
+func (i *indexCache) clear() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.built = nil
+}
+
+// index returns the items of FindAll by the value of the Index of field, building it on first use
+func (api Api[T, D]) index(ctx context.Context, field string, keyFn func(T) string) map[string][]T {
+	cache := &api.mount.indexes
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if index, ok := cache.built[field].(map[string][]T); ok {
+		return index
+	}
+	index := make(map[string][]T)
+	for _, item := range api.findAll(ctx) {
+		key := keyFn(item)
+		index[key] = append(index[key], item)
+	}
+	if timedOut(ctx) {
+		// A partial index is not kept
+		return index
+	}
+	if cache.built == nil {
+		cache.built = map[string]any{}
+	}
+	// The field is a path parameter, which Fiber reuses once the request completes
+	cache.built[strings.Clone(field)] = index
+	return index
+}
+
+// getByIndex returns the Dtos of the items whose :field Index is :value
+// 404 if the field is not indexed or no item has the value
+func getByIndex[T any, D any](api Api[T, D]) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Perms check
+		if allowed, err := api.authorize(c, ActionGetAll); !allowed {
+			return api.sendDenied(c, fiber.StatusUnauthorized, err)
+		}
+
+		field := c.Params("field")
+		keyFn, ok := api.Indexes[field]
+		if !ok {
+			return api.sendError(c, fiber.StatusNotFound, nil)
+		}
+		ctx, cancel := api.context(c)
+		defer cancel()
+		found := api.index(ctx, field, keyFn)[c.Params("value")]
+		if timedOut(ctx) {
+			return api.sendError(c, fiber.StatusGatewayTimeout, nil)
+		}
+		if len(found) == 0 {
+			return api.sendError(c, fiber.StatusNotFound, nil)
+		}
+
+		all, err := api.dtoAll(c, found)
+		if err != nil {
+			return api.sendDataError(c, err)
+		}
+		return api.send(c, ActionGetAll, all)
+	}
+}
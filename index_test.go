@@ -0,0 +1,62 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"testing"
+)
+
+func TestIndexes(t *testing.T) {
+	store := newWidgetStore(
+		widget{ID: "a", Name: "alpha", Status: "active"},
+		widget{ID: "b", Name: "beta", Status: "active"},
+		widget{ID: "c", Name: "gamma", Status: "retired"},
+	)
+	api := widgetApi(store)
+	api.Indexes = map[string]func(widget) string{
+		"status": func(w widget) string { return w.Status },
+	}
+	app := serve(api)
+
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/by/status/active", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if got := ids(t, body); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("active widgets %q, want [a b]", got)
+	}
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/by/status/missing", "")
+	expectStatus(t, resp, body, fiber.StatusNotFound)
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/by/name/alpha", "")
+	expectStatus(t, resp, body, fiber.StatusNotFound)
+
+	// A write rebuilds the index
+	resp, body = call(t, app, fiber.MethodPut, "/widgets/c", `{"name":"gamma","status":"active"}`)
+	expectStatus(t, resp, body, fiber.StatusOK)
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/by/status/active", "")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	if got := ids(t, body); len(got) != 3 {
+		t.Errorf("active widgets after the write %q, want [a b c]", got)
+	}
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/by/status/retired", "")
+	expectStatus(t, resp, body, fiber.StatusNotFound)
+}
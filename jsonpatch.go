@@ -0,0 +1,348 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MIMEApplicationJSONPatch is the content type of RFC 6902 JSON Patch documents
+const MIMEApplicationJSONPatch = "application/json-patch+json"
+
+// errPatchTestFailed is returned when a "test" operation of a patch does not hold
+var errPatchTestFailed = errors.New("patch test failed")
+
+// patchError is an invalid patch operation, or one that does not fit the document
+type patchError struct {
+	index int
+	op    string
+	msg   string
+}
+
+func (e *patchError) Error() string {
+	return fmt.Sprintf("patch operation %d (%s): %s", e.index, e.op, e.msg)
+}
+
+// patchOperation is a single RFC 6902 operation
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  *string         `json:"path"`
+	From  *string         `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// applyJSONPatch applies the RFC 6902 patch to the json document doc.
+// Operations are applied in order and the whole patch fails if any one does.
+// A failed "test" returns errPatchTestFailed, any other problem a *patchError.
+func applyJSONPatch(doc []byte, patch []byte) ([]byte, error) {
+	var ops []patchOperation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, &patchError{index: -1, op: "parse", msg: err.Error()}
+	}
+	root, err := decodeJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, op := range ops {
+		fail := func(msg string) error {
+			return &patchError{index: i, op: op.Op, msg: msg}
+		}
+		if op.Path == nil {
+			return nil, fail("missing path")
+		}
+		path, err := parsePointer(*op.Path)
+		if err != nil {
+			return nil, fail(err.Error())
+		}
+
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				return nil, fail("missing value")
+			}
+			value, err := decodeJSON(op.Value)
+			if err != nil {
+				return nil, fail(err.Error())
+			}
+			switch op.Op {
+			case "add":
+				root, err = pointerAdd(root, path, value)
+			case "replace":
+				if root, _, err = pointerRemove(root, path); err == nil {
+					root, err = pointerAdd(root, path, value)
+				}
+			case "test":
+				var current any
+				if current, err = pointerGet(root, path); err == nil && !jsonEqual(current, value) {
+					return nil, errPatchTestFailed
+				}
+			}
+			if err != nil {
+				return nil, fail(err.Error())
+			}
+		case "remove":
+			if root, _, err = pointerRemove(root, path); err != nil {
+				return nil, fail(err.Error())
+			}
+		case "move", "copy":
+			if op.From == nil {
+				return nil, fail("missing from")
+			}
+			from, err := parsePointer(*op.From)
+			if err != nil {
+				return nil, fail(err.Error())
+			}
+			var value any
+			if op.Op == "move" {
+				if *op.Path != *op.From && strings.HasPrefix(*op.Path, *op.From+"/") {
+					return nil, fail("cannot move a value into itself")
+				}
+				root, value, err = pointerRemove(root, from)
+			} else if value, err = pointerGet(root, from); err == nil {
+				// Copy the value so the two locations don't share containers
+				value, err = cloneJSON(value)
+			}
+			if err == nil {
+				root, err = pointerAdd(root, path, value)
+			}
+			if err != nil {
+				return nil, fail(err.Error())
+			}
+		default:
+			return nil, fail("unknown operation")
+		}
+	}
+	return json.Marshal(root)
+}
+
+// patchFields returns the top level fields the operations of the patch read or write.
+// An operation on the whole document returns a *patchError
+func patchFields(patch []byte) ([]string, error) {
+	var ops []patchOperation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, &patchError{index: -1, op: "parse", msg: err.Error()}
+	}
+	var fields []string
+	for i, op := range ops {
+		for _, pointer := range []*string{op.Path, op.From} {
+			if pointer == nil {
+				continue
+			}
+			path, err := parsePointer(*pointer)
+			if err == nil && len(path) == 0 {
+				return nil, &patchError{index: i, op: op.Op, msg: "cannot patch the whole document"}
+			}
+			if err == nil {
+				fields = append(fields, path[0])
+			}
+		}
+	}
+	return fields, nil
+}
+
+// decodeJSON decodes a json value, keeping numbers exact
+func decodeJSON(b []byte) (any, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v any
+	err := d.Decode(&v)
+	return v, err
+}
+
+// cloneJSON deep copies a decoded json value
+func cloneJSON(v any) (any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON(b)
+}
+
+// jsonEqual compares two decoded json values, numbers by value
+func jsonEqual(a any, b any) bool {
+	switch a := a.(type) {
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		x, errA := a.Float64()
+		y, errB := b.Float64()
+		return errA == nil && errB == nil && x == y
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			if w, ok := b[k]; !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !jsonEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into its unescaped reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path %q must start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// arrayIndex parses an array index token, valid indexes being below limit
+func arrayIndex(token string, limit int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i >= limit || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	return i, nil
+}
+
+// pointerGet returns the value at path
+func pointerGet(node any, path []string) (any, error) {
+	for _, token := range path {
+		switch n := node.(type) {
+		case map[string]any:
+			child, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("no member %q", token)
+			}
+			node = child
+		case []any:
+			i, err := arrayIndex(token, len(n))
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, fmt.Errorf("cannot descend into %q", token)
+		}
+	}
+	return node, nil
+}
+
+// pointerAdd adds value at path, inserting into arrays, and returns the updated node
+func pointerAdd(node any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	token, rest := path[0], path[1:]
+	switch n := node.(type) {
+	case map[string]any:
+		if len(rest) == 0 {
+			n[token] = value
+			return n, nil
+		}
+		child, ok := n[token]
+		if !ok {
+			return nil, fmt.Errorf("no member %q", token)
+		}
+		updated, err := pointerAdd(child, rest, value)
+		n[token] = updated
+		return n, err
+	case []any:
+		if len(rest) == 0 {
+			if token == "-" {
+				return append(n, value), nil
+			}
+			i, err := arrayIndex(token, len(n)+1)
+			if err != nil {
+				return nil, err
+			}
+			return append(n[:i], append([]any{value}, n[i:]...)...), nil
+		}
+		i, err := arrayIndex(token, len(n))
+		if err != nil {
+			return nil, err
+		}
+		updated, err := pointerAdd(n[i], rest, value)
+		n[i] = updated
+		return n, err
+	default:
+		return nil, fmt.Errorf("cannot descend into %q", token)
+	}
+}
+
+// pointerRemove removes the value at path and returns the updated node and the removed value
+func pointerRemove(node any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, node, nil
+	}
+	token, rest := path[0], path[1:]
+	switch n := node.(type) {
+	case map[string]any:
+		child, ok := n[token]
+		if !ok {
+			return nil, nil, fmt.Errorf("no member %q", token)
+		}
+		if len(rest) == 0 {
+			delete(n, token)
+			return n, child, nil
+		}
+		updated, removed, err := pointerRemove(child, rest)
+		n[token] = updated
+		return n, removed, err
+	case []any:
+		i, err := arrayIndex(token, len(n))
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(rest) == 0 {
+			removed := n[i]
+			return append(n[:i], n[i+1:]...), removed, nil
+		}
+		updated, removed, err := pointerRemove(n[i], rest)
+		n[i] = updated
+		return n, removed, err
+	default:
+		return nil, nil, fmt.Errorf("cannot descend into %q", token)
+	}
+}
@@ -0,0 +1,74 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestApplyJSONPatch(t *testing.T) {
+	doc := `{"name":"alpha","tags":["a","b"],"size":{"w":1}}`
+	tests := []struct {
+		name  string
+		patch string
+		want  string
+		err   bool
+	}{
+		{name: "add field", patch: `[{"op":"add","path":"/status","value":"active"}]`, want: `{"name":"alpha","size":{"w":1},"status":"active","tags":["a","b"]}`},
+		{name: "add to array", patch: `[{"op":"add","path":"/tags/1","value":"x"}]`, want: `{"name":"alpha","size":{"w":1},"tags":["a","x","b"]}`},
+		{name: "append to array", patch: `[{"op":"add","path":"/tags/-","value":"c"}]`, want: `{"name":"alpha","size":{"w":1},"tags":["a","b","c"]}`},
+		{name: "remove", patch: `[{"op":"remove","path":"/size/w"}]`, want: `{"name":"alpha","size":{},"tags":["a","b"]}`},
+		{name: "replace", patch: `[{"op":"replace","path":"/name","value":"beta"}]`, want: `{"name":"beta","size":{"w":1},"tags":["a","b"]}`},
+		{name: "test then replace", patch: `[{"op":"test","path":"/name","value":"alpha"},{"op":"replace","path":"/name","value":"beta"}]`, want: `{"name":"beta","size":{"w":1},"tags":["a","b"]}`},
+		{name: "move", patch: `[{"op":"move","from":"/name","path":"/title"}]`, want: `{"size":{"w":1},"tags":["a","b"],"title":"alpha"}`},
+		{name: "copy", patch: `[{"op":"copy","from":"/size","path":"/box"}]`, want: `{"box":{"w":1},"name":"alpha","size":{"w":1},"tags":["a","b"]}`},
+		{name: "remove missing", patch: `[{"op":"remove","path":"/missing"}]`, err: true},
+		{name: "replace missing", patch: `[{"op":"replace","path":"/missing","value":1}]`, err: true},
+		{name: "missing value", patch: `[{"op":"add","path":"/status"}]`, err: true},
+		{name: "bad index", patch: `[{"op":"add","path":"/tags/5","value":"x"}]`, err: true},
+		{name: "unknown op", patch: `[{"op":"merge","path":"/name","value":"x"}]`, err: true},
+		{name: "not an array", patch: `{"op":"add"}`, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyJSONPatch([]byte(doc), []byte(tt.patch))
+			var invalid *patchError
+			switch {
+			case tt.err && !errors.As(err, &invalid):
+				t.Errorf("error %v, want a patchError", err)
+			case !tt.err && err != nil:
+				t.Errorf("error %v", err)
+			case !tt.err && string(got) != tt.want:
+				t.Errorf("patched %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyJSONPatchTestFails(t *testing.T) {
+	patch := `[{"op":"test","path":"/name","value":"beta"},{"op":"replace","path":"/name","value":"gamma"}]`
+	if _, err := applyJSONPatch([]byte(`{"name":"alpha"}`), []byte(patch)); !errors.Is(err, errPatchTestFailed) {
+		t.Errorf("error %v, want errPatchTestFailed", err)
+	}
+}
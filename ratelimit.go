@@ -0,0 +1,125 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit is a token bucket limiting the requests of an action, per client.
+// Each client may burst Burst requests and is then refilled at Rate requests per second.
+// Requests beyond the limit are 429 (Too Many Requests) with a Retry-After of the refill time plus a random
+// wait of up to RetryJitter, so that limited clients do not all retry at once.
+type RateLimit struct {
+	Rate        float64
+	Burst       int
+	RetryJitter time.Duration
+	Key         func(c *fiber.Ctx) string // The client of a request, defaults to its IP
+}
+
+// maxRateBuckets is the number of clients tracked for a RateLimit before the idle ones are dropped
+const maxRateBuckets = 10000
+
+// bucket is the token bucket of a client
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter tracks the buckets of the clients of a RateLimit
+type limiter struct {
+	limit   RateLimit
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func newLimiter(limit RateLimit) *limiter {
+	return &limiter{limit: limit, buckets: map[string]*bucket{}}
+}
+
+// take takes a token for key at now, returning the wait until one is available if there is none
+func (l *limiter) take(key string, now time.Time) (ok bool, wait time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	burst := float64(max(l.limit.Burst, 1))
+	b, found := l.buckets[key]
+	if !found {
+		if len(l.buckets) >= maxRateBuckets {
+			l.prune(now)
+		}
+		b = &bucket{tokens: burst, last: now}
+		// The key may alias the request, such as a header value, which Fiber reuses once the request completes
+		l.buckets[strings.Clone(key)] = b
+	}
+	b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if l.limit.Rate <= 0 {
+		return false, time.Duration(math.MaxInt64)
+	}
+	return false, time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+}
+
+// prune drops the buckets that have refilled, their clients are idle
+func (l *limiter) prune(now time.Time) {
+	burst := float64(max(l.limit.Burst, 1))
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.limit.Rate >= burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// retryAfter is the Retry-After, in whole seconds, for a refill wait plus the jitter
+func (l *limiter) retryAfter(wait time.Duration) string {
+	if l.limit.RetryJitter > 0 {
+		// The wait saturates, a bucket that never refills waits forever
+		wait += min(rand.N(l.limit.RetryJitter), time.Duration(math.MaxInt64)-wait)
+	}
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
+
+// rateLimit answers the requests handler receives beyond the limits of l with 429 (Too Many Requests)
+func (api Api[T, D]) rateLimit(l *limiter, handler fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.IP()
+		if l.limit.Key != nil {
+			key = l.limit.Key(c)
+		}
+		if ok, wait := l.take(key, time.Now()); !ok {
+			c.Set(fiber.HeaderRetryAfter, l.retryAfter(wait))
+			return api.sendError(c, fiber.StatusTooManyRequests, nil)
+		}
+		return handler(c)
+	}
+}
@@ -0,0 +1,105 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRetryAfterJitter(t *testing.T) {
+	l := newLimiter(RateLimit{Rate: 0.5, Burst: 1, RetryJitter: 3 * time.Second})
+	now := time.Now()
+	if ok, _ := l.take("client", now); !ok {
+		t.Fatal("the first request was limited")
+	}
+	ok, wait := l.take("client", now)
+	if ok || wait != 2*time.Second {
+		t.Fatalf("took %v with a wait of %v, want a 2s refill", ok, wait)
+	}
+	seen := map[int]bool{}
+	for i := 0; i < 200; i++ {
+		retryAfter, err := strconv.Atoi(l.retryAfter(wait))
+		if err != nil || retryAfter < 2 || retryAfter > 5 {
+			t.Fatalf("Retry-After %d, %v, want 2 to 5 seconds", retryAfter, err)
+		}
+		seen[retryAfter] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("Retry-After always %v, want it jittered", seen)
+	}
+}
+
+func TestRetryAfterNeverRefills(t *testing.T) {
+	l := newLimiter(RateLimit{Rate: 0, Burst: 1, RetryJitter: time.Second})
+	now := time.Now()
+	l.take("client", now)
+	_, wait := l.take("client", now)
+	if retryAfter, err := strconv.Atoi(l.retryAfter(wait)); err != nil || retryAfter <= 0 {
+		t.Errorf("Retry-After %d, %v, want a saturated positive wait", retryAfter, err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	api := widgetApi(newWidgetStore(widget{ID: "a"}))
+	api.RateLimits = map[Action]RateLimit{
+		ActionGetOne: {Rate: 1, Burst: 2, RetryJitter: 2 * time.Second, Key: func(c *fiber.Ctx) string { return c.Get("X-Client") }},
+	}
+	app := serve(api)
+
+	for i := 0; i < 2; i++ {
+		resp, body := call(t, app, fiber.MethodGet, "/widgets/a", "", "X-Client", "ann")
+		expectStatus(t, resp, body, fiber.StatusOK)
+	}
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/a", "", "X-Client", "ann")
+	expectStatus(t, resp, body, fiber.StatusTooManyRequests)
+	if retryAfter, err := strconv.Atoi(resp.Header.Get(fiber.HeaderRetryAfter)); err != nil || retryAfter < 1 || retryAfter > 3 {
+		t.Errorf("Retry-After %q, want 1 to 3 seconds", resp.Header.Get(fiber.HeaderRetryAfter))
+	}
+
+	// Other clients and other actions are not limited
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/a", "", "X-Client", "bob")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/", "", "X-Client", "ann")
+	expectStatus(t, resp, body, fiber.StatusOK)
+}
+
+func TestRateLimitKeyOutlivesRequest(t *testing.T) {
+	api := widgetApi(newWidgetStore(widget{ID: "a"}))
+	api.RateLimits = map[Action]RateLimit{
+		ActionGetOne: {Rate: 0, Burst: 1, Key: func(c *fiber.Ctx) string { return c.Get("X-Client") }},
+	}
+	app := serve(api)
+
+	resp, body := call(t, app, fiber.MethodGet, "/widgets/a", "", "X-Client", "ann")
+	expectStatus(t, resp, body, fiber.StatusOK)
+	// Later requests reuse the buffers the key of ann was read from
+	for _, client := range []string{"bob", "cat", "dan", "eve", "fay", "gus", "hal", "ivy"} {
+		resp, body = call(t, app, fiber.MethodGet, "/widgets/a", "", "X-Client", client)
+		expectStatus(t, resp, body, fiber.StatusOK)
+	}
+	resp, body = call(t, app, fiber.MethodGet, "/widgets/a", "", "X-Client", "ann")
+	expectStatus(t, resp, body, fiber.StatusTooManyRequests)
+}
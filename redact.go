@@ -0,0 +1,78 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// redactedValue replaces the values of sensitive fields in logs
+const redactedValue = "***"
+
+// redact returns v for logging with the values of the fields named in sensitive replaced by "***".
+// Fields are matched case-insensitively by their json name, at any depth.  With no sensitive fields v is returned as is.
+func redact(v any, sensitive []string) any {
+	if len(sensitive) == 0 {
+		return v
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return redactedValue
+	}
+	var doc any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return redactedValue
+	}
+	redactValue(doc, sensitive)
+	b, _ = json.Marshal(doc)
+	return string(b)
+}
+
+// redactValue replaces the sensitive fields of the objects in doc in place
+func redactValue(doc any, sensitive []string) {
+	switch node := doc.(type) {
+	case map[string]any:
+		for key, value := range node {
+			if isSensitive(key, sensitive) {
+				node[key] = redactedValue
+				continue
+			}
+			redactValue(value, sensitive)
+		}
+	case []any:
+		for _, value := range node {
+			redactValue(value, sensitive)
+		}
+	}
+}
+
+// isSensitive reports if the field name is one of the sensitive fields
+func isSensitive(name string, sensitive []string) bool {
+	for _, field := range sensitive {
+		if strings.EqualFold(name, field) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"bytes"
+	"errors"
+	"github.com/gofiber/fiber/v2"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	v := map[string]any{"name": "ann", "Password": "hunter2", "nested": []any{map[string]any{"token": "abc"}}}
+	got := redact(v, []string{"password", "token"})
+	if want := `{"Password":"***","name":"ann","nested":[{"token":"***"}]}`; got != want {
+		t.Errorf("redacted %v, want %s", got, want)
+	}
+	if got := redact(v, nil); got == nil {
+		t.Error("redact without sensitive fields lost the value")
+	}
+}
+
+func TestRedactLog(t *testing.T) {
+	var logged bytes.Buffer
+	api := widgetApi(newWidgetStore())
+	api.Logger = slog.New(slog.NewTextHandler(&logged, nil))
+	api.SensitiveFields = []string{"owner"}
+	api.Create = func(d widgetDto) (widget, error) {
+		return widget{ID: d.ID, Owner: "secret-owner"}, errors.New("storage failure")
+	}
+
+	resp, body := call(t, serve(api), fiber.MethodPost, "/widgets/", `{"id":"a"}`)
+	expectStatus(t, resp, body, fiber.StatusInternalServerError)
+	if strings.Contains(logged.String(), "secret-owner") {
+		t.Errorf("the sensitive field was logged: %s", logged.String())
+	}
+	if !strings.Contains(logged.String(), `owner\":\"***\"`) {
+		t.Errorf("the log lacks the redacted field: %s", logged.String())
+	}
+}
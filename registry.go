@@ -0,0 +1,105 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// Registrar is anything that registers its routes on a router, such as an Api
+type Registrar interface {
+	Register(router fiber.Router)
+}
+
+// Register registers the Api on router, it is RegisterAPI as a method so an Api is a Registrar
+func (api Api[T, D]) Register(router fiber.Router) {
+	RegisterAPI(router, api)
+}
+
+// Registry collects Apis of any types to mount together.
+// Methods cannot take type parameters in Go, so Add takes any Registrar and the Api type parameters are
+// inferred where each Api is declared, e.g.
+//
+//	easyrest.NewRegistry().Add(users).Add(orders).Mount(app)
+type Registry struct {
+	apis []Registrar
+}
+
+// NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Add adds an Api to the registry, returning the registry for chaining
+func (r *Registry) Add(api Registrar) *Registry {
+	r.apis = append(r.apis, api)
+	return r
+}
+
+// Mount registers all the added Apis on router, in the order they were added
+func (r *Registry) Mount(router fiber.Router) {
+	for _, api := range r.apis {
+		api.Register(router)
+	}
+}
+
+// AnyApi is an Api of any types, as taken by easyresttest.TestSuite
+type AnyApi interface {
+	Registrar
+	// Routes lists the routes the Api exposes in registration order
+	Routes() []Route
+	// Prefix is the path the Api is mounted at under its router, /APIVersion/Path
+	Prefix() string
+	// Allowing returns a copy of the Api whose Validator always answers allowed, in place of any AuthPolicy
+	Allowing(allowed bool) AnyApi
+}
+
+// Route is a route exposed by an Api, its Path relative to the Api Prefix
+type Route struct {
+	Method string
+	Path   string
+}
+
+// Routes lists the routes the Api exposes in registration order
+func (api Api[T, D]) Routes() []Route {
+	var routes []Route
+	for _, r := range api.routes() {
+		routes = append(routes, Route{Method: r.method, Path: r.path})
+	}
+	return routes
+}
+
+// Prefix is the path the Api is mounted at under its router, /APIVersion/Path
+func (api Api[T, D]) Prefix() string {
+	return api.prefix()
+}
+
+// Allowing returns a copy of the Api whose Validator always answers allowed, in place of any AuthPolicy
+func (api Api[T, D]) Allowing(allowed bool) AnyApi {
+	clone := api.Clone()
+	clone.AuthPolicy = nil
+	clone.Validator = func(c *fiber.Ctx, action Action, item ...T) bool {
+		return allowed
+	}
+	return clone
+}
@@ -0,0 +1,56 @@
+// MIT License
+//
+// Copyright (c) 2023 Seth Osher
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package easyrest
+
+import (
+	"context"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Tracer starts the spans traced around each request.
+// It is deliberately small so that a shim over OpenTelemetry, or any other tracer, can implement it.
+type Tracer interface {
+	// Start starts a span called name, continuing any trace context propagated in the request headers of c.
+	// It returns the span and a context carrying it, derived from c.UserContext(), which becomes the user context of
+	// the request, so the data functions taking a context see the span.
+	Start(c *fiber.Ctx, name string) (context.Context, Span)
+}
+
+// Span is a single traced request.
+// Handlers annotate it with the "resource", "action" and "item.id" attributes, any error and the HTTP status.
+type Span interface {
+	SetAttribute(key string, value any)
+	RecordError(err error)
+	SetStatus(code int)
+	End()
+}
+
+// spanKey is the fiber.Ctx local holding the Span of the request
+const spanKey = "easyrest.span"
+
+// recordError records err on the Span of the request, if it is traced
+func recordError(c *fiber.Ctx, err error) {
+	if span, ok := c.Locals(spanKey).(Span); ok {
+		span.RecordError(err)
+	}
+}